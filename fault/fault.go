@@ -0,0 +1,262 @@
+// Package fault injects transport faults between the host and a TCN or ziman
+// board, to check that Process resyncs and that commands time out properly.
+package fault
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	// host to board, see Client
+	DIRECTION_OUTBOUND = "outbound"
+	// board to host, see Injector.Inbound and Injector.Reader
+	DIRECTION_INBOUND = "inbound"
+
+	// the chunk is not sent
+	ACTION_DROP = "drop"
+	// the chunk is sent after Delay
+	ACTION_DELAY = "delay"
+	// the chunk is sent twice
+	ACTION_DUPLICATE = "duplicate"
+	// the checksum byte of the chunk is changed
+	ACTION_CORRUPT = "corrupt"
+	// the chunk is sent in two parts
+	ACTION_SPLIT = "split"
+	// Bytes, or 1 to 4 random bytes, are sent before the chunk
+	ACTION_STRAY = "stray"
+)
+
+type (
+	// Script is a list of rules, applied in order to each chunk of data.
+	// Random decisions are driven by Seed, so a script gives the same faults
+	// every time it is run with the same traffic.
+	Script struct {
+		Seed  int64  `json:"seed"`
+		Rules []Rule `json:"rules"`
+	}
+
+	Rule struct {
+		Direction string `json:"direction"`
+		Action    string `json:"action"`
+		// chance (0 to 1) the rule applies to a chunk, 0 means always
+		Probability float64 `json:"probability"`
+		// number of chunks to let through before the rule applies
+		Skip int `json:"skip"`
+		// number of times the rule applies, 0 means no limit
+		Times int `json:"times"`
+		// milliseconds, used by ACTION_DELAY
+		Delay int `json:"delay"`
+		// used by ACTION_STRAY
+		Bytes []byte `json:"bytes"`
+	}
+
+	Event struct {
+		Time      time.Time
+		Rule      int
+		Direction string
+		Action    string
+		Bytes     []byte
+	}
+
+	// Injector applies the rules of a script. Use NewInjector to create one.
+	Injector struct {
+		mu      sync.Mutex
+		rules   []Rule
+		rand    *rand.Rand
+		seen    []int
+		applied []int
+		events  []Event
+	}
+
+	Board interface {
+		GetChannels() *sync.Map
+		Write([]byte) (int, error)
+	}
+
+	// Client wraps the Client of tcn/jsonrpc or ziman/jsonrpc and applies the
+	// outbound rules to each Write.
+	Client struct {
+		Board    Board
+		Injector *Injector
+	}
+
+	reader struct {
+		r        io.Reader
+		injector *Injector
+		buf      []byte
+		pending  [][]byte
+	}
+)
+
+func NewInjector(script Script) *Injector {
+	return &Injector{
+		rules:   script.Rules,
+		rand:    rand.New(rand.NewSource(script.Seed)),
+		seen:    make([]int, len(script.Rules)),
+		applied: make([]int, len(script.Rules)),
+	}
+}
+
+// Events returns the faults that have been injected.
+func (i *Injector) Events() []Event {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]Event{}, i.events...)
+}
+
+// Inbound applies the inbound rules to data and calls feed with each
+// resulting chunk, feed is usually the read loop calling tcn.Process or
+// ziman.Process.
+func (i *Injector) Inbound(data []byte, feed func([]byte)) {
+	i.apply(DIRECTION_INBOUND, data, func(chunk []byte) error {
+		feed(chunk)
+		return nil
+	})
+}
+
+// Reader returns a reader that applies the inbound rules to each read of r,
+// a split chunk is returned by two reads.
+func (i *Injector) Reader(r io.Reader) io.Reader {
+	return &reader{
+		r:        r,
+		injector: i,
+		buf:      make([]byte, 1024),
+	}
+}
+
+// apply sends each chunk after applying the matching rules to data, it stops
+// at the first error from send.
+func (i *Injector) apply(direction string, data []byte, send func([]byte) error) error {
+	var delay time.Duration
+	chunks := [][]byte{data}
+	i.mu.Lock()
+	for n, rule := range i.rules {
+		if rule.Direction != direction {
+			continue
+		}
+		i.seen[n]++
+		if i.seen[n] <= rule.Skip {
+			continue
+		}
+		if rule.Times > 0 && i.applied[n] >= rule.Times {
+			continue
+		}
+		if rule.Probability > 0 && i.rand.Float64() >= rule.Probability {
+			continue
+		}
+		i.applied[n]++
+		switch rule.Action {
+		case ACTION_DROP:
+			chunks = nil
+		case ACTION_DELAY:
+			delay += time.Duration(rule.Delay) * time.Millisecond
+		case ACTION_DUPLICATE:
+			chunks = append(chunks, chunks...)
+		case ACTION_CORRUPT:
+			for c, chunk := range chunks {
+				chunks[c] = corrupt(chunk)
+			}
+		case ACTION_SPLIT:
+			var split [][]byte
+			for _, chunk := range chunks {
+				if len(chunk) < 2 {
+					split = append(split, chunk)
+					continue
+				}
+				at := 1 + i.rand.Intn(len(chunk)-1)
+				split = append(split, chunk[:at], chunk[at:])
+			}
+			chunks = split
+		case ACTION_STRAY:
+			stray := rule.Bytes
+			if len(stray) == 0 {
+				stray = make([]byte, 1+i.rand.Intn(4))
+				i.rand.Read(stray)
+			}
+			if len(chunks) > 0 {
+				chunks[0] = append(append([]byte{}, stray...), chunks[0]...)
+			} else {
+				chunks = [][]byte{stray}
+			}
+		}
+		i.events = append(i.events, Event{
+			Time:      time.Now(),
+			Rule:      n,
+			Direction: direction,
+			Action:    rule.Action,
+			Bytes:     data,
+		})
+	}
+	i.mu.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+	for _, chunk := range chunks {
+		if err := send(chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Client) GetChannels() *sync.Map {
+	return c.Board.GetChannels()
+}
+
+// Write returns len(p) even if p is dropped, like a serial port would.
+func (c *Client) Write(p []byte) (int, error) {
+	if c.Injector == nil {
+		return c.Board.Write(p)
+	}
+	err := c.Injector.apply(DIRECTION_OUTBOUND, p, func(chunk []byte) error {
+		_, err := c.Board.Write(chunk)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		n, err := r.r.Read(r.buf)
+		if n > 0 {
+			data := append([]byte{}, r.buf[:n]...)
+			r.injector.Inbound(data, func(chunk []byte) {
+				r.pending = append(r.pending, chunk)
+			})
+		}
+		if err != nil {
+			if len(r.pending) > 0 {
+				break
+			}
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending[0])
+	if n < len(r.pending[0]) {
+		r.pending[0] = r.pending[0][n:]
+	} else {
+		r.pending = r.pending[1:]
+	}
+	return n, nil
+}
+
+// corrupt returns a copy of chunk with the checksum byte changed, which is
+// the second last byte of a ziman frame and the last byte of a TCN frame.
+func corrupt(chunk []byte) []byte {
+	if len(chunk) == 0 {
+		return chunk
+	}
+	out := append([]byte{}, chunk...)
+	index := len(out) - 1
+	if out[0] == 0xa8 && len(out) > 1 {
+		index = len(out) - 2
+	}
+	out[index] ^= 0xff
+	return out
+}
@@ -0,0 +1,149 @@
+package fault_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/fault"
+	"github.com/caiguanhao/vending-processors/tcn"
+	tcnsim "github.com/caiguanhao/vending-processors/tcn/sim"
+	"github.com/caiguanhao/vending-processors/ziman"
+	zimansim "github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func inbound(i *fault.Injector, data []byte) (chunks [][]byte) {
+	i.Inbound(data, func(chunk []byte) {
+		chunks = append(chunks, chunk)
+	})
+	return
+}
+
+func TestInjectorActions(t *testing.T) {
+	data := []byte{1, 2, 3, 4}
+	tests := []struct {
+		rule fault.Rule
+		want [][]byte
+	}{
+		{fault.Rule{Action: fault.ACTION_DROP}, nil},
+		{fault.Rule{Action: fault.ACTION_DUPLICATE}, [][]byte{data, data}},
+		{fault.Rule{Action: fault.ACTION_CORRUPT}, [][]byte{{1, 2, 3, 0xFB}}},
+		{fault.Rule{Action: fault.ACTION_STRAY, Bytes: []byte{9}}, [][]byte{{9, 1, 2, 3, 4}}},
+	}
+	for _, test := range tests {
+		test.rule.Direction = fault.DIRECTION_INBOUND
+		i := fault.NewInjector(fault.Script{Rules: []fault.Rule{test.rule}})
+		if got := inbound(i, data); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: chunks = %v, want %v", test.rule.Action, got, test.want)
+		}
+		if events := i.Events(); len(events) != 1 || events[0].Action != test.rule.Action {
+			t.Errorf("%s: events = %+v", test.rule.Action, events)
+		}
+	}
+}
+
+func TestInjectorSkipTimes(t *testing.T) {
+	i := fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_DROP, Skip: 1, Times: 2},
+		// never applies to inbound data
+		{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DUPLICATE},
+	}})
+	var got []int
+	for n := 0; n < 5; n++ {
+		got = append(got, len(inbound(i, []byte{byte(n)})))
+	}
+	if want := []int{1, 0, 0, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chunks = %v, want %v", got, want)
+	}
+}
+
+func TestInjectorSeed(t *testing.T) {
+	script := fault.Script{
+		Seed: 42,
+		Rules: []fault.Rule{
+			{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_SPLIT, Probability: 0.5},
+		},
+	}
+	run := func() (out [][]byte) {
+		i := fault.NewInjector(script)
+		for n := 0; n < 20; n++ {
+			out = append(out, inbound(i, []byte{0, 1, 2, 3, 4, 5, 6, 7})...)
+		}
+		return
+	}
+	if a, b := run(), run(); !reflect.DeepEqual(a, b) {
+		t.Error("the same seed gives different faults")
+	}
+}
+
+func TestInjectorReader(t *testing.T) {
+	i := fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_SPLIT},
+	}})
+	data := []byte{1, 2, 3, 4, 5}
+	r := i.Reader(bytes.NewReader(data))
+	first := make([]byte, 10)
+	n, err := r.Read(first)
+	if err != nil || n == 0 || n == len(data) {
+		t.Fatalf("n = %d, err = %v, want part of the data", n, err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if got := append(first[:n], rest...); !bytes.Equal(got, data) {
+		t.Errorf("data = %v, want %v", got, data)
+	}
+}
+
+func TestTCNResync(t *testing.T) {
+	board := tcnsim.NewBoard()
+	board.Faults = fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_CORRUPT, Times: 1},
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_STRAY, Bytes: []byte{0x02, 0xFF}},
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_SPLIT},
+	}})
+	client := &fault.Client{
+		Board: board,
+		Injector: fault.NewInjector(fault.Script{Rules: []fault.Rule{
+			{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DROP, Skip: 1, Times: 1},
+		}}),
+	}
+	m := &tcn.Machine{Client: client, HideLogs: true}
+	check := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		return m.Check(ctx)
+	}
+	// corrupted reply, dropped command, then both recovered
+	for n, want := range []error{tcn.ErrTimeout, tcn.ErrTimeout, nil, nil} {
+		if err := check(); err != want {
+			t.Errorf("check #%d: err = %v, want %v", n+1, err, want)
+		}
+	}
+}
+
+func TestZimanResync(t *testing.T) {
+	board := zimansim.NewBoard()
+	board.Faults = fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_CORRUPT, Times: 1},
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_STRAY, Bytes: []byte{0xa8, 0x09}},
+		{Direction: fault.DIRECTION_INBOUND, Action: fault.ACTION_SPLIT},
+	}})
+	m := &ziman.Machine{Client: board}
+	status := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		_, err := m.Status(ctx)
+		return err
+	}
+	for n, want := range []error{ziman.ErrTimeout, nil, nil} {
+		if err := status(); err != want {
+			t.Errorf("status #%d: err = %v, want %v", n+1, err, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+// Package idempotency keeps the replies of commands that have a request ID,
+// so that a retry with the same ID gets the reply of the first call instead
+// of running the command again.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"sync"
+	"time"
+)
+
+var ErrMismatch = errors.New("request ID has been used with different arguments")
+
+type (
+	// Results keeps the replies of the commands, its zero value is ready to
+	// use.
+	Results struct {
+		mu      sync.Mutex
+		results map[string]*result
+	}
+
+	result struct {
+		args     [sha256.Size]byte
+		done     chan struct{}
+		finished time.Time
+		reply    reflect.Value
+		err      error
+	}
+)
+
+// Run calls command unless it has been called with the same key within
+// window milliseconds (600000 if 0), in which case reply, a pointer, is set
+// to the kept reply. If that command is still running, Run waits for it. It
+// returns ErrMismatch if that command had different args, which should be
+// the arguments of the command only, not the ones like a timeout that only
+// change how the call waits. The reply is not kept if command returns one of
+// notSent, errors returned before a command is written, so that a retry with
+// the same key runs the command, as does a call that has been waiting for it.
+func (r *Results) Run(window int, key string, args, reply interface{}, notSent []error, command func() error) error {
+	hash, err := hashOf(args)
+	if err != nil {
+		return err
+	}
+	if window == 0 {
+		window = 600000
+	}
+	r.mu.Lock()
+	r.expire(time.Now().Add(-time.Duration(window) * time.Millisecond))
+	for {
+		previous, ok := r.results[key]
+		if !ok {
+			break
+		}
+		r.mu.Unlock()
+		if previous.args != hash {
+			return ErrMismatch
+		}
+		<-previous.done
+		if !isNotSent(previous.err, notSent) {
+			if previous.reply.IsValid() {
+				reflect.ValueOf(reply).Elem().Set(previous.reply)
+			}
+			return previous.err
+		}
+		// nothing has been sent, run it unless another call already does
+		r.mu.Lock()
+	}
+	if r.results == nil {
+		r.results = map[string]*result{}
+	}
+	current := &result{
+		args: hash,
+		done: make(chan struct{}),
+	}
+	r.results[key] = current
+	r.mu.Unlock()
+
+	err = command()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current.finished = time.Now()
+	current.err = err
+	if isNotSent(err, notSent) {
+		delete(r.results, key)
+	} else {
+		value := reflect.ValueOf(reply).Elem()
+		current.reply = reflect.New(value.Type()).Elem()
+		current.reply.Set(value)
+	}
+	close(current.done)
+	return err
+}
+
+// expire removes the replies of the commands finished before t, the lock is
+// held.
+func (r *Results) expire(t time.Time) {
+	for key, result := range r.results {
+		if !result.finished.IsZero() && result.finished.Before(t) {
+			delete(r.results, key)
+		}
+	}
+}
+
+func hashOf(args interface{}) (hash [sha256.Size]byte, err error) {
+	data, err := json.Marshal(args)
+	if err != nil {
+		return
+	}
+	hash = sha256.Sum256(data)
+	return
+}
+
+func isNotSent(err error, notSent []error) bool {
+	for _, e := range notSent {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
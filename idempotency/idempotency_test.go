@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type args struct {
+	Number int
+}
+
+func TestRun(t *testing.T) {
+	var r Results
+	calls := 0
+	command := func(reply *int) func() error {
+		return func() error {
+			calls++
+			*reply = calls
+			return nil
+		}
+	}
+	var a, b int
+	if err := r.Run(0, "a", args{1}, &a, nil, command(&a)); err != nil || a != 1 {
+		t.Fatalf("a = %d, err = %v", a, err)
+	}
+	if err := r.Run(0, "a", args{1}, &b, nil, command(&b)); err != nil || b != 1 || calls != 1 {
+		t.Errorf("b = %d, calls = %d, err = %v", b, calls, err)
+	}
+	if err := r.Run(0, "a", args{2}, &b, nil, command(&b)); err != ErrMismatch || calls != 1 {
+		t.Errorf("calls = %d, err = %v, want ErrMismatch", calls, err)
+	}
+	if err := r.Run(0, "b", args{2}, &b, nil, command(&b)); err != nil || b != 2 {
+		t.Errorf("b = %d, err = %v", b, err)
+	}
+}
+
+func TestRunNotSent(t *testing.T) {
+	var r Results
+	errNotSent := errors.New("not sent")
+	errSent := errors.New("sent")
+	calls := 0
+	var reply bool
+	for _, want := range []error{errNotSent, errNotSent} {
+		err := r.Run(0, "a", args{1}, &reply, []error{errNotSent}, func() error {
+			calls++
+			return want
+		})
+		if err != want {
+			t.Errorf("err = %v, want %v", err, want)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		err := r.Run(0, "a", args{1}, &reply, []error{errNotSent}, func() error {
+			calls++
+			return errSent
+		})
+		if err != errSent {
+			t.Errorf("err = %v, want %v", err, errSent)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("calls = %d, want 3", calls)
+	}
+}
+
+func TestRunWindow(t *testing.T) {
+	var r Results
+	calls := 0
+	var reply int
+	for i := 0; i < 2; i++ {
+		r.Run(20, "a", args{1}, &reply, nil, func() error {
+			calls++
+			return nil
+		})
+		time.Sleep(40 * time.Millisecond)
+	}
+	// the window has passed, different args are a new command
+	if err := r.Run(20, "a", args{2}, &reply, nil, func() error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+}
+
+func TestRunWaits(t *testing.T) {
+	var r Results
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var first, second int
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.Run(0, "a", args{1}, &first, nil, func() error {
+			close(started)
+			<-release
+			first = 42
+			return nil
+		})
+	}()
+	<-started
+	done := make(chan error)
+	go func() {
+		done <- r.Run(0, "a", args{1}, &second, nil, func() error {
+			t.Error("command should not be called again")
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+		t.Fatal("Run should wait for the running command")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	if err := <-done; err != nil || second != 42 {
+		t.Errorf("second = %d, err = %v", second, err)
+	}
+	wg.Wait()
+}
+
+func TestRunWaitsNotSent(t *testing.T) {
+	var r Results
+	errBusy := errors.New("busy")
+	release := make(chan struct{})
+	started := make(chan struct{})
+	var first, second int
+	go r.Run(0, "a", args{1}, &first, []error{errBusy}, func() error {
+		close(started)
+		<-release
+		return errBusy
+	})
+	<-started
+	done := make(chan error)
+	go func() {
+		done <- r.Run(0, "a", args{1}, &second, []error{errBusy}, func() error {
+			second = 42
+			return nil
+		})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	// the first command has sent nothing, so the waiting call runs its own
+	if err := <-done; err != nil || second != 42 {
+		t.Errorf("second = %d, err = %v", second, err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package probe finds out whether a newly connected board speaks TCN or
+// ziman.
+package probe
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/transport"
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+const (
+	PROTOCOL_TCN   = "tcn"
+	PROTOCOL_ZIMAN = "ziman"
+
+	CAPABILITY_DISPENSE    = "dispense"
+	CAPABILITY_STATUS      = "status"
+	CAPABILITY_TEMPERATURE = "temperature"
+	CAPABILITY_LIGHTS      = "lights"
+	CAPABILITY_LIFTER      = "lifter"
+)
+
+var (
+	ErrUnknownProtocol = errors.New("unknown protocol")
+	// Probe needs to stop reading when it returns, so that no reply is
+	// taken from the read loop that comes after it
+	ErrNoDeadline = errors.New("read deadline is not supported")
+)
+
+type (
+	Result struct {
+		Protocol     string   `json:"protocol"`
+		Capabilities []string `json:"capabilities"`
+		// tcn.Process or ziman.Process
+		Processor transport.Processor `json:"-"`
+		// the reply that was recognised
+		Bytes []byte `json:"bytes"`
+	}
+
+	// Options of Probe, zero values use the defaults.
+	Options struct {
+		// milliseconds to wait for each reply, defaults to 1000
+		Wait int
+	}
+
+	// ReadWriter is what Probe needs, e.g. net.Conn or an os.File opened
+	// with O_NONBLOCK.
+	ReadWriter interface {
+		io.ReadWriter
+		SetReadDeadline(time.Time) error
+	}
+
+	prober struct {
+		rw   ReadWriter
+		wait time.Duration
+	}
+)
+
+// Probe sends a TCN check (0xDF), which boards ignore if they do not speak
+// TCN, and then a ziman status frame, and returns the protocol of the first
+// one that gets a full valid reply of its own protocol. A TCN board is also
+// asked for its lifter status. No read is pending when Probe returns.
+func Probe(ctx context.Context, rw io.ReadWriter, options Options) (Result, error) {
+	wait := time.Duration(options.Wait) * time.Millisecond
+	if wait == 0 {
+		wait = time.Second
+	}
+	d, ok := rw.(ReadWriter)
+	if !ok {
+		return Result{}, ErrNoDeadline
+	}
+	p := &prober{
+		rw:   d,
+		wait: wait,
+	}
+	defer d.SetReadDeadline(time.Time{})
+
+	frame, err := p.askTCN(ctx, tcn.BasicBytes(0xDF, 0x55), func(f tcn.Frame) bool {
+		return f.Type == tcn.FRAME_BASIC
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if frame != nil {
+		result := Result{
+			Protocol: PROTOCOL_TCN,
+			Capabilities: []string{
+				CAPABILITY_DISPENSE, CAPABILITY_STATUS, CAPABILITY_TEMPERATURE, CAPABILITY_LIGHTS,
+			},
+			Processor: tcn.Process,
+			Bytes:     frame,
+		}
+		lifter, err := p.askTCN(ctx, tcn.LifterBytes(tcn.FUNC_LIFTER_GET_STATUS, 0x00), func(f tcn.Frame) bool {
+			return f.Type == tcn.FRAME_LIFTER && f.Bytes[2] == tcn.FUNC_LIFTER_GET_STATUS
+		})
+		if err != nil {
+			return Result{}, err
+		}
+		if lifter != nil {
+			result.Capabilities = append(result.Capabilities, CAPABILITY_LIFTER)
+		}
+		return result, nil
+	}
+	frame, err = p.askZiman(ctx, ziman.FrameBytes(ziman.FUNC_STATUS, 0, []byte{0x02, 0x02}), func(f ziman.Frame) bool {
+		return f.Function == ziman.FUNC_STATUS && len(f.Bytes) == 9
+	})
+	if err != nil {
+		return Result{}, err
+	}
+	if frame != nil {
+		return Result{
+			Protocol:     PROTOCOL_ZIMAN,
+			Capabilities: []string{CAPABILITY_DISPENSE, CAPABILITY_STATUS},
+			Processor:    ziman.Process,
+			Bytes:        frame,
+		}, nil
+	}
+	return Result{}, ErrUnknownProtocol
+}
+
+// askTCN writes input and returns the first TCN frame that is valid, or nil
+// if there is none in time.
+func (p *prober) askTCN(ctx context.Context, input []byte, valid func(tcn.Frame) bool) ([]byte, error) {
+	decoder := tcn.NewDecoder(nil)
+	return p.ask(ctx, input, decoder, func() []byte {
+		for {
+			frame, err := decoder.Decode()
+			if err != nil {
+				return nil
+			}
+			if valid(frame) {
+				return frame.Bytes
+			}
+		}
+	})
+}
+
+// askZiman writes input and returns the first ziman frame that is valid, or
+// nil if there is none in time.
+func (p *prober) askZiman(ctx context.Context, input []byte, valid func(ziman.Frame) bool) ([]byte, error) {
+	decoder := ziman.NewDecoder(nil)
+	return p.ask(ctx, input, decoder, func() []byte {
+		for {
+			frame, err := decoder.Decode()
+			if err != nil {
+				return nil
+			}
+			if valid(frame) {
+				return frame.Bytes
+			}
+		}
+	})
+}
+
+// ask writes input and feeds what is read to w until decoded returns a frame
+// or the wait is over.
+func (p *prober) ask(ctx context.Context, input []byte, w io.Writer, decoded func() []byte) ([]byte, error) {
+	if _, err := p.rw.Write(input); err != nil {
+		return nil, err
+	}
+	waitCtx, cancel := context.WithTimeout(ctx, p.wait)
+	defer cancel()
+	for {
+		if frame := decoded(); frame != nil {
+			return frame, nil
+		}
+		chunk, err := p.read(waitCtx)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if waitCtx.Err() != nil {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		w.Write(chunk)
+	}
+}
+
+// read returns the next chunk, or nil if nothing arrives in 100 milliseconds.
+func (p *prober) read(ctx context.Context) ([]byte, error) {
+	deadline := time.Now().Add(100 * time.Millisecond)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := p.rw.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 256)
+	n, err := p.rw.Read(buf)
+	// the timeout of an *os.File is an *os.PathError, not a net.Error
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = nil
+	}
+	return buf[:n], err
+}
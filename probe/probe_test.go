@@ -0,0 +1,169 @@
+package probe
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+// board replies to each command it receives with the reply returns, nothing
+// is sent if it returns nil.
+func board(t *testing.T, reply func(command []byte) []byte) net.Conn {
+	host, device := net.Pipe()
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := device.Read(buf)
+			if err != nil {
+				return
+			}
+			if out := reply(buf[:n]); out != nil {
+				device.Write(out)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		host.Close()
+		device.Close()
+	})
+	return host
+}
+
+// pipe is the host end of a board over os.Pipe, which like a serial port is
+// an *os.File whose read timeouts are *os.PathError.
+type pipe struct {
+	*os.File
+	w *os.File
+}
+
+func (p pipe) Write(b []byte) (int, error) {
+	return p.w.Write(b)
+}
+
+// fileBoard is board over os.Pipe.
+func fileBoard(t *testing.T, reply func(command []byte) []byte) pipe {
+	hostR, deviceW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	deviceR, hostW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		buf := make([]byte, 256)
+		for {
+			n, err := deviceR.Read(buf)
+			if err != nil {
+				return
+			}
+			if out := reply(buf[:n]); out != nil {
+				deviceW.Write(out)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		for _, f := range []*os.File{hostR, hostW, deviceR, deviceW} {
+			f.Close()
+		}
+	})
+	return pipe{hostR, hostW}
+}
+
+func tcnBoard(lifter bool) func([]byte) []byte {
+	return func(command []byte) []byte {
+		switch {
+		case command[0] == 0x00:
+			return []byte{0x00, 0x5D, 0x00, 0x00, 0x5D}
+		case command[0] == 0x02 && lifter:
+			return tcn.LifterBytes(tcn.FUNC_LIFTER_GET_STATUS, 0x00, 0x00, 0x00)
+		}
+		return nil
+	}
+}
+
+func zimanBoard(command []byte) []byte {
+	if command[0] == 0xa8 && command[2] == ziman.FUNC_STATUS {
+		return ziman.FrameBytes(ziman.FUNC_STATUS, command[3], []byte{5, 8, 1})
+	}
+	return nil
+}
+
+func TestProbe(t *testing.T) {
+	tests := []struct {
+		name         string
+		board        func([]byte) []byte
+		protocol     string
+		capabilities []string
+		err          error
+	}{
+		{"tcn", tcnBoard(false), PROTOCOL_TCN, []string{CAPABILITY_DISPENSE, CAPABILITY_STATUS, CAPABILITY_TEMPERATURE, CAPABILITY_LIGHTS}, nil},
+		{"lifter", tcnBoard(true), PROTOCOL_TCN, []string{CAPABILITY_DISPENSE, CAPABILITY_STATUS, CAPABILITY_TEMPERATURE, CAPABILITY_LIGHTS, CAPABILITY_LIFTER}, nil},
+		{"ziman", zimanBoard, PROTOCOL_ZIMAN, []string{CAPABILITY_DISPENSE, CAPABILITY_STATUS}, nil},
+		{"silent", func([]byte) []byte { return nil }, "", nil, ErrUnknownProtocol},
+		// a ziman status frame in reply to the TCN check is not taken as TCN
+		{"ziman to anything", func([]byte) []byte {
+			return ziman.FrameBytes(ziman.FUNC_STATUS, 0, []byte{5, 8, 1})
+		}, PROTOCOL_ZIMAN, []string{CAPABILITY_DISPENSE, CAPABILITY_STATUS}, nil},
+		// a partial TCN reply is not enough
+		{"partial", func([]byte) []byte { return []byte{0x00, 0x5D, 0x00} }, "", nil, ErrUnknownProtocol},
+	}
+	for _, test := range tests {
+		result, err := Probe(context.Background(), board(t, test.board), Options{Wait: 100})
+		if err != test.err || result.Protocol != test.protocol || !reflect.DeepEqual(result.Capabilities, test.capabilities) {
+			t.Errorf("%s: result = %+v, err = %v", test.name, result, err)
+		}
+	}
+}
+
+func TestProbeFile(t *testing.T) {
+	// the boards leave a command unanswered, so reads time out
+	if result, err := Probe(context.Background(), fileBoard(t, tcnBoard(false)), Options{Wait: 100}); err != nil || result.Protocol != PROTOCOL_TCN {
+		t.Errorf("tcn: result = %+v, err = %v", result, err)
+	}
+	if result, err := Probe(context.Background(), fileBoard(t, zimanBoard), Options{Wait: 100}); err != nil || result.Protocol != PROTOCOL_ZIMAN {
+		t.Errorf("ziman: result = %+v, err = %v", result, err)
+	}
+}
+
+func TestProbeStopsReading(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	defer device.Close()
+	go func() {
+		buf := make([]byte, 256)
+		device.Read(buf)
+		device.Write([]byte{0x00, 0x5D, 0x00, 0x00, 0x5D})
+		device.Read(buf)
+	}()
+	if _, err := Probe(context.Background(), host, Options{Wait: 100}); err != nil {
+		t.Fatal(err)
+	}
+	// the next reply is for the read loop that comes after Probe
+	reply := []byte{0x00, 0x5D, 0x00, 0xAA, 0x07}
+	go device.Write(reply)
+	host.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 256)
+	n, err := host.Read(buf)
+	if err != nil || !bytes.Equal(buf[:n], reply) {
+		t.Errorf("read = % X, err = %v", buf[:n], err)
+	}
+}
+
+func TestProbeNoDeadline(t *testing.T) {
+	var rw struct {
+		io.Reader
+		io.Writer
+	}
+	if _, err := Probe(context.Background(), rw, Options{}); err != ErrNoDeadline {
+		t.Errorf("err = %v, want ErrNoDeadline", err)
+	}
+}
@@ -0,0 +1,34 @@
+package jsonrpc
+
+import (
+	"github.com/caiguanhao/vending-processors/registry"
+)
+
+type (
+	Registry struct {
+		Registry *registry.Registry
+	}
+
+	ListArgs struct {
+		// only list connected clients
+		Connected bool `json:"connected"`
+	}
+
+	ListReply struct {
+		Clients []registry.Info `json:"clients"`
+	}
+)
+
+func (r *Registry) List(args *ListArgs, reply *ListReply) error {
+	reply.Clients = []registry.Info{}
+	if r.Registry == nil {
+		return nil
+	}
+	for _, info := range r.Registry.List() {
+		if args.Connected && !info.Connected {
+			continue
+		}
+		reply.Clients = append(reply.Clients, info)
+	}
+	return nil
+}
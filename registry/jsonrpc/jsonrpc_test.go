@@ -0,0 +1,25 @@
+package jsonrpc
+
+import (
+	"testing"
+
+	"github.com/caiguanhao/vending-processors/registry"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func TestList(t *testing.T) {
+	r := &Registry{Registry: registry.New()}
+	r.Registry.Add("a", sim.NewBoard(), registry.Metadata{Protocol: "tcn"})
+	r.Registry.Add("b", sim.NewBoard(), registry.Metadata{Protocol: "tcn"})
+	r.Registry.Remove("b")
+	var reply ListReply
+	if err := r.List(&ListArgs{}, &reply); err != nil || len(reply.Clients) != 2 {
+		t.Errorf("clients = %+v, err = %v", reply.Clients, err)
+	}
+	if err := r.List(&ListArgs{Connected: true}, &reply); err != nil || len(reply.Clients) != 1 || reply.Clients[0].ID != "a" {
+		t.Errorf("connected clients = %+v, err = %v", reply.Clients, err)
+	}
+	if err := (&Registry{}).List(&ListArgs{}, &reply); err != nil || reply.Clients == nil || len(reply.Clients) != 0 {
+		t.Errorf("no registry: clients = %+v, err = %v", reply.Clients, err)
+	}
+}
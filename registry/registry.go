@@ -0,0 +1,242 @@
+// Package registry keeps track of the clients of tcn/jsonrpc and
+// ziman/jsonrpc, whether they are connected and when they were last seen.
+package registry
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/transport"
+)
+
+const (
+	EVENT_CONNECTED    = "connected"
+	EVENT_DISCONNECTED = "disconnected"
+)
+
+type (
+	Client interface {
+		GetChannels() *sync.Map
+		Write([]byte) (int, error)
+	}
+
+	Metadata struct {
+		Model    string `json:"model"`
+		Location string `json:"location"`
+		// e.g. the Protocol and Capabilities of probe.Result
+		Protocol     string   `json:"protocol"`
+		Capabilities []string `json:"capabilities"`
+	}
+
+	Info struct {
+		ID             string    `json:"id"`
+		Metadata       Metadata  `json:"metadata"`
+		Connected      bool      `json:"connected"`
+		ConnectedAt    time.Time `json:"connected_at"`
+		DisconnectedAt time.Time `json:"disconnected_at"`
+		// last time bytes were received from the client
+		LastSeen time.Time `json:"last_seen"`
+	}
+
+	Event struct {
+		Type string    `json:"type"`
+		Time time.Time `json:"time"`
+		Info Info      `json:"info"`
+	}
+
+	// Registry holds the connected clients in a map that can be used as
+	// the Clients of tcn/jsonrpc.TCN or ziman/jsonrpc.Ziman, and remembers
+	// the clients that have been disconnected. Use New to create one.
+	Registry struct {
+		// milliseconds a disconnected client is still listed, defaults to
+		// 86400000, negative means until Forget is called
+		KeepDisconnected int
+
+		clients sync.Map
+
+		mu          sync.Mutex
+		infos       map[string]*Info
+		ids         map[*sync.Map]string
+		subscribers map[chan Event]struct{}
+	}
+)
+
+func New() *Registry {
+	return &Registry{
+		infos:       map[string]*Info{},
+		ids:         map[*sync.Map]string{},
+		subscribers: map[chan Event]struct{}{},
+	}
+}
+
+// Map returns the connected clients, keyed by ID.
+func (r *Registry) Map() *sync.Map {
+	return &r.clients
+}
+
+// Add adds or replaces the client and marks it connected.
+func (r *Registry) Add(id string, client Client, metadata Metadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	info, ok := r.infos[id]
+	if !ok {
+		info = &Info{ID: id}
+		r.infos[id] = info
+	}
+	info.Metadata = metadata
+	info.Connected = true
+	info.ConnectedAt = now
+	info.LastSeen = now
+	if previous, ok := r.clients.Load(id); ok {
+		delete(r.ids, previous.(Client).GetChannels())
+	}
+	r.ids[client.GetChannels()] = id
+	r.clients.Store(id, client)
+	r.publish(EVENT_CONNECTED, now, *info)
+	r.expire(now)
+}
+
+// Remove marks the client disconnected, it is still listed for
+// KeepDisconnected or until Forget is called.
+func (r *Registry) Remove(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info, ok := r.infos[id]
+	if !ok || !info.Connected {
+		return
+	}
+	now := time.Now()
+	info.Connected = false
+	info.DisconnectedAt = now
+	if client, ok := r.clients.LoadAndDelete(id); ok {
+		delete(r.ids, client.(Client).GetChannels())
+	}
+	r.publish(EVENT_DISCONNECTED, now, *info)
+	r.expire(now)
+}
+
+// Forget removes the client from the list.
+func (r *Registry) Forget(id string) {
+	r.Remove(id)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.infos, id)
+}
+
+// List returns all clients sorted by ID.
+func (r *Registry) List() []Info {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(time.Now())
+	list := make([]Info, 0, len(r.infos))
+	for _, info := range r.infos {
+		list = append(list, *info)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+func (r *Registry) Get(id string) (Info, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.expire(time.Now())
+	info, ok := r.infos[id]
+	if !ok {
+		return Info{}, false
+	}
+	return *info, true
+}
+
+func (r *Registry) SetMetadata(id string, metadata Metadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.infos[id]; ok {
+		info.Metadata = metadata
+	}
+}
+
+// Touch updates the last seen time of the client.
+func (r *Registry) Touch(id string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info, ok := r.infos[id]; ok {
+		info.LastSeen = time.Now()
+	}
+}
+
+// Processor returns a processor that touches the connected client every time
+// bytes are received for it and then calls process. The client is found by
+// the channels it is called with, so one processor can be given to a
+// transport before the client_id is known, e.g. to tcp.Listen. As it wraps
+// process, it can not be used when the transport detects the protocol with
+// probe.Probe, Touch has to be called by other means then.
+func (r *Registry) Processor(process transport.Processor) transport.Processor {
+	return func(data []byte, multiChannels ...*sync.Map) []byte {
+		r.touch(multiChannels)
+		return process(data, multiChannels...)
+	}
+}
+
+func (r *Registry) touch(multiChannels []*sync.Map) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, channels := range multiChannels {
+		if info, ok := r.infos[r.ids[channels]]; ok {
+			info.LastSeen = time.Now()
+		}
+	}
+}
+
+// Subscribe returns a channel of events and a function to stop receiving
+// them. Events are dropped if the channel is full.
+func (r *Registry) Subscribe(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	r.mu.Lock()
+	r.subscribers[events] = struct{}{}
+	r.mu.Unlock()
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			r.mu.Lock()
+			delete(r.subscribers, events)
+			r.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+// expire forgets the clients disconnected for longer than KeepDisconnected,
+// the lock is held.
+func (r *Registry) expire(now time.Time) {
+	keep := r.KeepDisconnected
+	if keep == 0 {
+		keep = 86400000
+	}
+	if keep < 0 {
+		return
+	}
+	for id, info := range r.infos {
+		if !info.Connected && now.Sub(info.DisconnectedAt) > time.Duration(keep)*time.Millisecond {
+			delete(r.infos, id)
+		}
+	}
+}
+
+// publish sends the event to subscribers, the lock is held.
+func (r *Registry) publish(eventType string, t time.Time, info Info) {
+	event := Event{
+		Type: eventType,
+		Time: t,
+		Info: info,
+	}
+	for events := range r.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
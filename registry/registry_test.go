@@ -0,0 +1,110 @@
+package registry
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type client struct {
+	channels sync.Map
+}
+
+func (c *client) GetChannels() *sync.Map {
+	return &c.channels
+}
+
+func (c *client) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func TestRegistry(t *testing.T) {
+	r := New()
+	events, stop := r.Subscribe(10)
+	defer stop()
+	r.Add("b", &client{}, Metadata{Model: "tcn"})
+	r.Add("a", &client{}, Metadata{Model: "ziman"})
+	r.Remove("b")
+	r.Remove("b") // already disconnected
+	list := r.List()
+	if len(list) != 2 || list[0].ID != "a" || !list[0].Connected || list[1].ID != "b" || list[1].Connected {
+		t.Errorf("list = %+v", list)
+	}
+	if _, ok := r.Map().Load("b"); ok {
+		t.Error("b is still in the map")
+	}
+	for _, want := range []string{"connected b", "connected a", "disconnected b"} {
+		event := <-events
+		if got := event.Type + " " + event.Info.ID; got != want {
+			t.Errorf("event = %s, want %s", got, want)
+		}
+	}
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	default:
+	}
+	r.Forget("a")
+	if event := <-events; event.Type != EVENT_DISCONNECTED || event.Info.ID != "a" {
+		t.Errorf("event = %+v", event)
+	}
+	if _, ok := r.Get("a"); ok {
+		t.Error("a is not forgotten")
+	}
+}
+
+func TestRegistryKeepDisconnected(t *testing.T) {
+	r := New()
+	r.KeepDisconnected = 20
+	for _, id := range []string{"a", "b"} {
+		r.Add(id, &client{}, Metadata{})
+		r.Remove(id)
+	}
+	r.Add("c", &client{}, Metadata{})
+	if list := r.List(); len(list) != 3 {
+		t.Errorf("list = %+v", list)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if list := r.List(); len(list) != 1 || list[0].ID != "c" {
+		t.Errorf("list = %+v, want only the connected client", list)
+	}
+	r.KeepDisconnected = -1
+	r.Remove("c")
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := r.Get("c"); !ok {
+		t.Error("c is forgotten, want kept until Forget")
+	}
+}
+
+func TestRegistryProcessor(t *testing.T) {
+	r := New()
+	a, b := &client{}, &client{}
+	var processed bool
+	// given before the clients are added, like to tcp.Listen
+	process := r.Processor(func(data []byte, multiChannels ...*sync.Map) []byte {
+		processed = true
+		return nil
+	})
+	r.Add("a", a, Metadata{})
+	r.Add("b", b, Metadata{})
+	beforeA, _ := r.Get("a")
+	beforeB, _ := r.Get("b")
+	time.Sleep(10 * time.Millisecond)
+	process([]byte{0x00}, a.GetChannels())
+	afterA, _ := r.Get("a")
+	afterB, _ := r.Get("b")
+	if !processed || !afterA.LastSeen.After(beforeA.LastSeen) {
+		t.Errorf("processed = %v, last seen = %s, was %s", processed, afterA.LastSeen, beforeA.LastSeen)
+	}
+	if !afterB.LastSeen.Equal(beforeB.LastSeen) {
+		t.Errorf("b is touched: last seen = %s, was %s", afterB.LastSeen, beforeB.LastSeen)
+	}
+	// a removed client is not touched
+	r.Remove("a")
+	removed, _ := r.Get("a")
+	time.Sleep(10 * time.Millisecond)
+	process([]byte{0x00}, a.GetChannels())
+	if after, _ := r.Get("a"); !after.LastSeen.Equal(removed.LastSeen) {
+		t.Errorf("removed a is touched: last seen = %s, was %s", after.LastSeen, removed.LastSeen)
+	}
+}
@@ -0,0 +1,135 @@
+// Package retry tries the commands of a board again when they fail with an
+// error that is often transient or before they are written.
+package retry
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	// commands that only read
+	READ = "read"
+	// commands that drive a motor
+	MOTOR = "motor"
+)
+
+type (
+	// Retry is how many times and how often a class of commands is tried.
+	Retry struct {
+		// number of tries in total, 0 or 1 means no retry
+		Attempts int
+		// milliseconds before the first retry, doubled after each retry,
+		// defaults to 200
+		Backoff int
+		// milliseconds, defaults to 5000
+		MaxBackoff int
+		// milliseconds each try waits for the reply, 0 means the deadline
+		// of ctx or the default timeout of the command
+		Timeout int
+	}
+
+	// Policy is the Retry of each class of commands. A read command is tried
+	// again after a transient error or an error returned before it was
+	// written. A motor command is only tried again after an error returned
+	// before it was written, as the board may have moved it otherwise.
+	Policy struct {
+		Read  Retry
+		Motor Retry
+	}
+
+	Attempt struct {
+		// e.g. "status"
+		Command string
+		Time    time.Time
+		// milliseconds
+		Duration int
+		// nil if the command succeeded
+		Error error
+	}
+
+	Command struct {
+		// READ or MOTOR
+		Class string
+		// name of the command in Attempt
+		Name string
+		Run  func(context.Context) error
+		// reports whether the error is often transient
+		Transient func(error) bool
+		// reports whether the error is returned before the command is
+		// written
+		NotSent func(error) bool
+	}
+)
+
+// Do calls command.Run until it succeeds, it fails with an error that may
+// not be retried, the attempts of the class are used up or ctx is done. A
+// nil Policy tries it once. onAttempt, if not nil, is called after every
+// try.
+func (p *Policy) Do(ctx context.Context, command Command, onAttempt func(Attempt)) error {
+	var retry Retry
+	if p != nil {
+		if command.Class == MOTOR {
+			retry = p.Motor
+		} else {
+			retry = p.Read
+		}
+	}
+	backoff := retry.Backoff
+	if backoff == 0 {
+		backoff = 200
+	}
+	maxBackoff := retry.MaxBackoff
+	if maxBackoff == 0 {
+		maxBackoff = 5000
+	}
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := try(ctx, retry.Timeout, command.Run)
+		if onAttempt != nil {
+			onAttempt(Attempt{
+				Command:  command.Name,
+				Time:     start,
+				Duration: int(time.Since(start) / time.Millisecond),
+				Error:    err,
+			})
+		}
+		if err == nil || attempt >= retry.Attempts {
+			return err
+		}
+		if !retriable(command, err) {
+			return err
+		}
+		timer := time.NewTimer(time.Duration(backoff) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// retriable reports whether command may be tried again after err.
+func retriable(command Command, err error) bool {
+	if command.NotSent != nil && command.NotSent(err) {
+		return true
+	}
+	if command.Class == MOTOR {
+		return false
+	}
+	return command.Transient != nil && command.Transient(err)
+}
+
+// try calls run with ctx limited to timeout milliseconds.
+func try(ctx context.Context, timeout int, run func(context.Context) error) error {
+	if timeout == 0 {
+		return run(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+	defer cancel()
+	return run(ctx)
+}
@@ -0,0 +1,96 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+var (
+	errTransient = errors.New("transient")
+	errNotSent   = errors.New("not sent")
+	errOther     = errors.New("other")
+)
+
+func command(class string, errs ...error) (Command, *int) {
+	calls := new(int)
+	return Command{
+		Class: class,
+		Name:  "test",
+		Run: func(context.Context) error {
+			*calls++
+			if *calls > len(errs) {
+				return nil
+			}
+			return errs[*calls-1]
+		},
+		Transient: func(err error) bool { return err == errTransient },
+		NotSent:   func(err error) bool { return err == errNotSent },
+	}, calls
+}
+
+func TestDo(t *testing.T) {
+	policy := &Policy{
+		Read:  Retry{Attempts: 3, Backoff: 1},
+		Motor: Retry{Attempts: 3, Backoff: 1},
+	}
+	tests := []struct {
+		name   string
+		policy *Policy
+		class  string
+		errs   []error
+		want   error
+		calls  int
+	}{
+		{"no policy", nil, READ, []error{errTransient}, errTransient, 1},
+		{"read transient", policy, READ, []error{errTransient, errTransient}, nil, 3},
+		{"read used up", policy, READ, []error{errTransient, errTransient, errTransient}, errTransient, 3},
+		{"read other", policy, READ, []error{errOther}, errOther, 1},
+		{"motor not sent", policy, MOTOR, []error{errNotSent}, nil, 2},
+		{"motor transient", policy, MOTOR, []error{errTransient}, errTransient, 1},
+		{"motor other", policy, MOTOR, []error{errOther}, errOther, 1},
+	}
+	for _, test := range tests {
+		c, calls := command(test.class, test.errs...)
+		var attempts []Attempt
+		err := test.policy.Do(context.Background(), c, func(a Attempt) {
+			attempts = append(attempts, a)
+		})
+		if err != test.want || *calls != test.calls || len(attempts) != test.calls {
+			t.Errorf("%s: err = %v, calls = %d, attempts = %d, want %v and %d calls", test.name, err, *calls, len(attempts), test.want, test.calls)
+		}
+		if attempts[0].Command != "test" || (attempts[0].Error == nil) != (len(test.errs) == 0) {
+			t.Errorf("%s: first attempt = %+v", test.name, attempts[0])
+		}
+	}
+}
+
+func TestDoDone(t *testing.T) {
+	policy := &Policy{Read: Retry{Attempts: 5, Backoff: 1000}}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c, calls := command(READ, errTransient, errTransient)
+	if err := policy.Do(ctx, c, nil); err != errTransient || *calls != 1 {
+		t.Errorf("err = %v, calls = %d", err, *calls)
+	}
+}
+
+func TestDoNoBackoff(t *testing.T) {
+	policy := &Policy{
+		Read:  Retry{Attempts: 3, Backoff: 1000},
+		Motor: Retry{Attempts: 3, Backoff: 1000},
+	}
+	for _, class := range []string{READ, MOTOR} {
+		err := errTransient
+		if class == READ {
+			err = errOther
+		}
+		c, _ := command(class, err)
+		start := time.Now()
+		policy.Do(context.Background(), c, nil)
+		if d := time.Since(start); d > 500*time.Millisecond {
+			t.Errorf("%s: took %v without a retry", class, d)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package slots keeps the statistics of the slots of a board from the
+// results of the commands that drive their motors, and disables a slot after
+// too many failures in a row.
+package slots
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDisabled   = errors.New("slot is disabled")
+	ErrNotTracked = errors.New("slot is not tracked")
+)
+
+type (
+	// Health keeps the Stats of the slots of every client, a slot is any
+	// comparable value, e.g. the number of the slot or its row and column.
+	// Its zero value is ready to use.
+	Health struct {
+		mu      sync.Mutex
+		clients map[string]map[interface{}]*Stats
+		// slots with a command running, closed when it is done
+		busy map[string]map[interface{}]chan struct{}
+	}
+
+	Stats struct {
+		Attempts int
+		Failures int
+		// failures since the last success or Enable
+		ConsecutiveFailures int
+		// milliseconds, commands that timed out are left out
+		AverageDuration float64
+		// error code or reply of the board or the error of the last failure
+		LastErrorCode string
+		LastAttemptAt time.Time
+		Disabled      bool
+		DisabledAt    time.Time
+
+		// number of commands in AverageDuration
+		results int
+	}
+)
+
+// All returns the statistics of the slots of the client.
+func (h *Health) All(clientId string) map[interface{}]Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	all := map[interface{}]Stats{}
+	for slot, stats := range h.clients[clientId] {
+		all[slot] = *stats
+	}
+	return all
+}
+
+func (h *Health) Get(clientId string, slot interface{}) (Stats, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats, ok := h.clients[clientId][slot]
+	if !ok {
+		return Stats{}, false
+	}
+	return *stats, true
+}
+
+func (h *Health) Disabled(clientId string, slot interface{}) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats, ok := h.clients[clientId][slot]
+	return ok && stats.Disabled
+}
+
+// Enable enables the slot and resets its consecutive failures, e.g. after
+// it has been fixed. It returns ErrNotTracked if no command has been
+// recorded for the slot.
+func (h *Health) Enable(clientId string, slot interface{}) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	stats, ok := h.clients[clientId][slot]
+	if !ok {
+		return ErrNotTracked
+	}
+	stats.Disabled = false
+	stats.DisabledAt = time.Time{}
+	stats.ConsecutiveFailures = 0
+	return nil
+}
+
+// Reserve marks the slot as running a command until release is called. If
+// another command to the slot is running, it waits for it or until ctx is
+// done, so that no command is sent to a slot that the other command has just
+// disabled. It fails with ErrDisabled if the slot is disabled.
+func (h *Health) Reserve(ctx context.Context, clientId string, slot interface{}) (release func(), err error) {
+	h.mu.Lock()
+	for {
+		if stats, ok := h.clients[clientId][slot]; ok && stats.Disabled {
+			h.mu.Unlock()
+			return nil, ErrDisabled
+		}
+		done, ok := h.busy[clientId][slot]
+		if !ok {
+			break
+		}
+		h.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		h.mu.Lock()
+	}
+	defer h.mu.Unlock()
+	if h.busy == nil {
+		h.busy = map[string]map[interface{}]chan struct{}{}
+	}
+	if h.busy[clientId] == nil {
+		h.busy[clientId] = map[interface{}]chan struct{}{}
+	}
+	done := make(chan struct{})
+	h.busy[clientId][slot] = done
+	return func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		delete(h.busy[clientId], slot)
+		close(done)
+	}, nil
+}
+
+// Record adds the result of a command to the slot, duration is negative if
+// the command timed out and errorCode is ignored if ok is true. disabled is
+// true if the slot has just been disabled after maxFailures consecutive
+// failures, which defaults to 3, negative means never.
+func (h *Health) Record(clientId string, slot interface{}, maxFailures, duration int, ok bool, errorCode string) (stats Stats, disabled bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.clients == nil {
+		h.clients = map[string]map[interface{}]*Stats{}
+	}
+	slots, exists := h.clients[clientId]
+	if !exists {
+		slots = map[interface{}]*Stats{}
+		h.clients[clientId] = slots
+	}
+	s, exists := slots[slot]
+	if !exists {
+		s = &Stats{}
+		slots[slot] = s
+	}
+	if duration >= 0 {
+		s.AverageDuration = (s.AverageDuration*float64(s.results) + float64(duration)) / float64(s.results+1)
+		s.results++
+	}
+	s.Attempts++
+	s.LastAttemptAt = time.Now()
+	if ok {
+		s.ConsecutiveFailures = 0
+		return *s, false
+	}
+	s.Failures++
+	s.ConsecutiveFailures++
+	s.LastErrorCode = errorCode
+	if maxFailures == 0 {
+		maxFailures = 3
+	}
+	if maxFailures > 0 && !s.Disabled && s.ConsecutiveFailures >= maxFailures {
+		s.Disabled = true
+		s.DisabledAt = time.Now()
+		disabled = true
+	}
+	return *s, disabled
+}
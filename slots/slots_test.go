@@ -0,0 +1,71 @@
+package slots
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	var h Health
+	if err := h.Enable("a", 1); err != ErrNotTracked {
+		t.Errorf("enable untracked: err = %v, want ErrNotTracked", err)
+	}
+	h.Record("a", 1, 2, 100, true, "")
+	h.Record("a", 1, 2, -1, false, "timeout")
+	stats, disabled := h.Record("a", 1, 2, 300, false, "FF")
+	if !disabled || !stats.Disabled || stats.Attempts != 3 || stats.Failures != 2 || stats.AverageDuration != 200 || stats.LastErrorCode != "FF" {
+		t.Errorf("stats = %+v, disabled = %v", stats, disabled)
+	}
+	if _, disabled := h.Record("a", 1, 2, 100, false, "FF"); disabled {
+		t.Error("disabled again")
+	}
+	if !h.Disabled("a", 1) || h.Disabled("a", 2) || h.Disabled("b", 1) {
+		t.Error("wrong slot disabled")
+	}
+	if _, err := h.Reserve(context.Background(), "a", 1); err != ErrDisabled {
+		t.Errorf("err = %v, want ErrDisabled", err)
+	}
+	if err := h.Enable("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := h.Get("a", 1); stats.Disabled || stats.ConsecutiveFailures != 0 {
+		t.Errorf("after enable: stats = %+v", stats)
+	}
+	if all := h.All("a"); len(all) != 1 || all[1].Attempts != 4 {
+		t.Errorf("all = %+v", all)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	var h Health
+	ctx := context.Background()
+	release, err := h.Reserve(ctx, "a", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other slots and clients are not blocked
+	for _, c := range []struct {
+		client string
+		slot   int
+	}{{"a", 2}, {"b", 1}} {
+		r, err := h.Reserve(ctx, c.client, c.slot)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r()
+	}
+	short, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err := h.Reserve(short, "a", 1); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want DeadlineExceeded", err)
+	}
+	go func() {
+		h.Record("a", 1, 1, 0, false, "FF")
+		release()
+	}()
+	// the slot is disabled by the command it waited for
+	if _, err := h.Reserve(ctx, "a", 1); err != ErrDisabled {
+		t.Errorf("err = %v, want ErrDisabled", err)
+	}
+}
@@ -0,0 +1,116 @@
+package tcn
+
+import (
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	FRAME_BASIC  = iota // 0x00 0x5D ... replies
+	FRAME_LIFTER        // 0x02 ... 0x03 replies
+)
+
+type (
+	Frame struct {
+		Type  int
+		Bytes []byte
+	}
+
+	// Decoder turns a stream of bytes read from a TCN board into frames. Bytes
+	// can be pushed with Write or pulled from the io.Reader given to
+	// NewDecoder.
+	Decoder struct {
+		r    io.Reader
+		read []byte
+
+		mu     sync.Mutex
+		buf    []byte
+		frames []Frame
+	}
+)
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		r:    r,
+		read: make([]byte, 256),
+	}
+}
+
+// Key returns the channel key the frame is dispatched to, see FunctionKey.
+func (f Frame) Key() string {
+	if f.Type == FRAME_BASIC {
+		return KEY_DEFAULT
+	}
+	return FunctionKey(f.Bytes[2])
+}
+
+func (f Frame) Dispatch(multiChannels ...*sync.Map) {
+	dispatch(f.Key(), f.Bytes, multiChannels...)
+}
+
+func (d *Decoder) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf = append(d.buf, p...)
+	d.buf = scan(d.buf, d.found)
+	return len(p), nil
+}
+
+// Buffered returns the number of bytes waiting for the rest of a frame.
+func (d *Decoder) Buffered() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.buf)
+}
+
+// Decode returns the next frame, reading from the underlying reader if no
+// decoded frame is pending. It returns io.EOF if there is no reader.
+func (d *Decoder) Decode() (Frame, error) {
+	for {
+		d.mu.Lock()
+		if len(d.frames) > 0 {
+			frame := d.frames[0]
+			d.frames = d.frames[1:]
+			d.mu.Unlock()
+			return frame, nil
+		}
+		d.mu.Unlock()
+		if d.r == nil {
+			return Frame{}, io.EOF
+		}
+		n, err := d.r.Read(d.read)
+		if n > 0 {
+			d.Write(d.read[:n])
+		}
+		if err != nil && d.pending() == 0 {
+			return Frame{}, err
+		}
+	}
+}
+
+// Run decodes frames until the reader fails and sends each of them to the
+// matching channel, like Process does.
+func (d *Decoder) Run(multiChannels ...*sync.Map) error {
+	for {
+		frame, err := d.Decode()
+		if err != nil {
+			return err
+		}
+		log.Println("received", frame.Bytes)
+		frame.Dispatch(multiChannels...)
+	}
+}
+
+func (d *Decoder) pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.frames)
+}
+
+func (d *Decoder) found(frameType int, data []byte) {
+	d.frames = append(d.frames, Frame{
+		Type:  frameType,
+		Bytes: append([]byte(nil), data...),
+	})
+}
@@ -0,0 +1,141 @@
+package tcn
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+var testBasicReply = []byte{0x00, 0x5D, 0x00, 0xAA, 0x07}
+
+func TestScanOrder(t *testing.T) {
+	lifter := LifterBytes(FUNC_LIFTER_GET_STATUS, 0x00, 0x00, 0x00)
+	input := append(append([]byte{0xFF}, lifter...), testBasicReply...)
+	var frames []Frame
+	rest := scan(input, func(frameType int, data []byte) {
+		frames = append(frames, Frame{Type: frameType, Bytes: data})
+	})
+	if len(rest) != 0 {
+		t.Errorf("rest = % X, want none", rest)
+	}
+	if len(frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(frames))
+	}
+	if frames[0].Type != FRAME_LIFTER || !bytes.Equal(frames[0].Bytes, lifter) {
+		t.Errorf("first frame = %v, want the lifter reply", frames[0])
+	}
+	if frames[1].Type != FRAME_BASIC || !bytes.Equal(frames[1].Bytes, testBasicReply) {
+		t.Errorf("second frame = %v, want the basic reply", frames[1])
+	}
+}
+
+func TestScanKeepsPartialFrame(t *testing.T) {
+	lifter := LifterBytes(FUNC_LIFTER_SHIP, 0x00, 0x00)
+	input := append(append([]byte{}, testBasicReply...), 0x12, 0x34)
+	input = append(input, lifter[:3]...)
+	n := 0
+	rest := scan(input, func(int, []byte) { n++ })
+	if n != 1 {
+		t.Errorf("got %d frames, want 1", n)
+	}
+	if !bytes.Equal(rest, lifter[:3]) {
+		t.Errorf("rest = % X, want % X", rest, lifter[:3])
+	}
+	rest = scan(append(rest, lifter[3:]...), func(frameType int, data []byte) {
+		n++
+		if frameType != FRAME_LIFTER || !bytes.Equal(data, lifter) {
+			t.Errorf("frame = % X, want % X", data, lifter)
+		}
+	})
+	if n != 2 || len(rest) != 0 {
+		t.Errorf("got %d frames and rest % X, want 2 frames and no rest", n, rest)
+	}
+}
+
+func TestScanDropsGarbage(t *testing.T) {
+	// bad checksum, then bytes that can not start a frame
+	input := []byte{0x00, 0x5D, 0x00, 0xAA, 0x08, 0x11, 0x22}
+	rest := scan(input, func(_ int, data []byte) {
+		t.Errorf("unexpected frame % X", data)
+	})
+	if len(rest) != 0 {
+		t.Errorf("rest = % X, want none", rest)
+	}
+	// consumed bytes are not scanned again
+	input = append(append([]byte{}, testBasicReply...), 0x00)
+	n := 0
+	rest = scan(input, func(int, []byte) { n++ })
+	rest = scan(append(rest, 0x5D, 0x00, 0xAA, 0x07), func(int, []byte) { n++ })
+	if n != 2 || len(rest) != 0 {
+		t.Errorf("got %d frames and rest % X, want 2 frames and no rest", n, rest)
+	}
+}
+
+func TestProcessDispatch(t *testing.T) {
+	channels := &sync.Map{}
+	basic := make(chan []byte, 1)
+	status := make(chan []byte, 1)
+	channels.Store(KEY_DEFAULT, basic)
+	channels.Store(KEY_STATUS, status)
+	lifter := LifterBytes(FUNC_LIFTER_GET_STATUS, 0x00, 0x00, 0x00)
+	Process(append(append([]byte{}, lifter...), testBasicReply...), channels)
+	if got := <-status; !bytes.Equal(got, lifter) {
+		t.Errorf("status = % X, want % X", got, lifter)
+	}
+	if got := <-basic; !bytes.Equal(got, testBasicReply) {
+		t.Errorf("default = % X, want % X", got, testBasicReply)
+	}
+}
+
+func TestProcessDropped(t *testing.T) {
+	channels := &sync.Map{}
+	basic := make(chan []byte, 1)
+	channels.Store(KEY_DEFAULT, basic)
+	// the second reply has nobody waiting for it
+	Process(append(append([]byte{}, testBasicReply...), testBasicReply...), channels)
+	if dropped := Dropped(channels); dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	if dropped := Dropped(&sync.Map{}); dropped != 0 {
+		t.Errorf("dropped of another client = %d, want 0", dropped)
+	}
+	if got := <-basic; !bytes.Equal(got, testBasicReply) {
+		t.Errorf("default = % X, want % X", got, testBasicReply)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	lifter := LifterBytes(FUNC_LIFTER_CHECK_EXISTENCE, 0x00, 0x01)
+	stream := append(append([]byte{0x55}, testBasicReply...), lifter...)
+	d := NewDecoder(&oneByteReader{data: stream})
+	for _, want := range [][]byte{testBasicReply, lifter} {
+		frame, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame.Bytes, want) {
+			t.Errorf("frame = % X, want % X", frame.Bytes, want)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+	if d.Buffered() != 0 {
+		t.Errorf("buffered = %d, want 0", d.Buffered())
+	}
+}
+
+// oneByteReader splits every frame across reads.
+type oneByteReader struct {
+	data []byte
+}
+
+func (r *oneByteReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	p[0] = r.data[0]
+	r.data = r.data[1:]
+	return 1, nil
+}
@@ -0,0 +1,26 @@
+package jsonrpc
+
+import (
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+// notSent are errors returned before a command is written, they are not kept
+// so that a retry with the same request ID runs the command.
+var notSent = []error{
+	ErrNoSuchClient,
+	ErrProcessing,
+	tcn.ErrSlotDisabled,
+	tcn.ErrQueueFull,
+	tcn.ErrQueueTimeout,
+}
+
+// idempotent calls command unless it has been called with the same method,
+// client and request ID within IdempotencyWindow, see idempotency.Results.
+// args are the arguments of the command, without the timeout of the call.
+func (t *TCN) idempotent(method, clientId, requestId string, args, reply interface{}, command func() error) error {
+	if requestId == "" {
+		return command()
+	}
+	key := method + "/" + clientId + "/" + requestId
+	return t.results.Run(t.IdempotencyWindow, key, args, reply, notSent, command)
+}
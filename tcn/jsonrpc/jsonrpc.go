@@ -1,34 +1,47 @@
 package jsonrpc
 
 import (
-	"bytes"
-	"errors"
-	"fmt"
-	"log"
+	"context"
 	"sync"
 	"time"
 
+	"github.com/caiguanhao/vending-processors/idempotency"
 	"github.com/caiguanhao/vending-processors/tcn"
 )
 
 var (
-	ErrTimeout      = errors.New("timeout")
-	ErrProcessing   = errors.New("already processing")
-	ErrNoContent    = errors.New("no content")
-	ErrNoSuchClient = errors.New("no such client")
+	ErrTimeout      = tcn.ErrTimeout
+	ErrProcessing   = tcn.ErrProcessing
+	ErrNoContent    = tcn.ErrNoContent
+	ErrNoSuchClient = tcn.ErrNoSuchClient
 )
 
 type (
 	TCN struct {
 		Clients *sync.Map
-
-		hideLogs bool
-	}
-
-	Client interface {
-		GetChannels() *sync.Map
-		Write([]byte) (int, error)
-	}
+		// optional, commands to a busy client wait in the queue instead of
+		// failing with ErrProcessing
+		Queue *tcn.Queue
+		// optional, retries commands that fail with a timeout
+		Retry *tcn.RetryPolicy
+		// optional, keeps the statistics of the slots and disables a slot
+		// after too many failures
+		SlotHealth *tcn.SlotHealth
+		// enables SendRaw
+		ExpertMode bool
+		// milliseconds the reply of Rotate, LifterShip or LifterShipStart is
+		// kept for its request ID, defaults to 600000
+		IdempotencyWindow int
+		// milliseconds the result of LifterShipStart is kept once the lifter
+		// is done, defaults to 600000
+		ShipResultTTL int
+
+		shipsMu sync.Mutex
+		ships   map[string]*lifterShip
+		results idempotency.Results
+	}
+
+	Client = tcn.Client
 
 	BasicArgs struct {
 		ClientID string `json:"client_id"`
@@ -43,6 +56,10 @@ type (
 		BasicArgs
 		Number  int `json:"number"`
 		Timeout int `json:"timeout"`
+		// optional, a retry with the same ID gets the reply of the first
+		// call instead of dispensing again, or idempotency.ErrMismatch if
+		// the other arguments differ
+		RequestID string `json:"request_id"`
 	}
 
 	TurnOnRefrigeratorArgs struct {
@@ -50,15 +67,33 @@ type (
 		Temperature int    `json:"temperature"`
 	}
 
+	CheckReply struct {
+		OK bool `json:"ok"`
+		// tries of CheckWithAttempts, see TCN.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
+	}
+
+	RotateReply struct {
+		// true if the board replied with success
+		Success bool `json:"success"`
+		// tries of RotateWithAttempts, see TCN.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
+	}
+
 	StatusReply struct {
-		Time              time.Time `json:"time"`
-		ActualTemperature int       `json:"actual_temperature"`
+		Time              time.Time      `json:"time"`
+		ActualTemperature int            `json:"actual_temperature"`
+		Attempts          []AttemptReply `json:"attempts,omitempty"`
 	}
 
 	LifterShipArgs struct {
 		BasicArgs
 		Number  int `json:"number"`
 		Timeout int `json:"timeout"`
+		// optional, a retry with the same ID gets the reply of the first
+		// call instead of dispensing again, or idempotency.ErrMismatch if
+		// the other arguments differ
+		RequestID string `json:"request_id"`
 	}
 
 	LifterMoveArgs struct {
@@ -71,384 +106,399 @@ type (
 		OK         bool   `json:"ok"`
 		StatusCode string `json:"status_code"`
 		ErrorCode  string `json:"error_code"`
+		// nil if there is no error
+		Error *tcn.LifterError `json:"error"`
+		// tries of LifterStatus and LifterShip, see TCN.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
+	}
+
+	AttemptReply struct {
+		Command string    `json:"command"`
+		Time    time.Time `json:"time"`
+		// milliseconds
+		Duration int    `json:"duration"`
+		Error    string `json:"error"`
 	}
 
 	LifterExistenceReply struct {
 		Bytes  Hex   `json:"bytes"`
 		Exists *bool `json:"exists"`
 	}
+
+	LifterQueryParametersArgs struct {
+		BasicArgs
+		Index int `json:"index"`
+	}
+
+	LifterDataReply struct {
+		Bytes Hex `json:"bytes"`
+		Data  Hex `json:"data"`
+	}
+
+	LifterParameterReply struct {
+		LifterDataReply
+		Index int `json:"index"`
+		// last two bytes of data
+		Value int `json:"value"`
+	}
 )
 
-func (t *TCN) Check(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xDF, 0x55), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+// Machine returns the Go API of the client, which the RPC methods of TCN are
+// built on.
+func (t *TCN) Machine(clientId string) (*tcn.Machine, error) {
+	if t.Clients == nil {
+		return nil, ErrNoSuchClient
+	}
+	_client, ok := t.Clients.Load(clientId)
+	if !ok {
+		return nil, ErrNoSuchClient
+	}
+	client, ok := _client.(Client)
+	if !ok {
+		return nil, ErrNoSuchClient
+	}
+	return &tcn.Machine{
+		ID:         clientId,
+		Client:     client,
+		Queue:      t.Queue,
+		Retry:      t.Retry,
+		SlotHealth: t.SlotHealth,
+	}, nil
 }
 
-func (t *TCN) MergeCell(args *CellArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xCA, byte(args.Number)), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) Check(args *BasicArgs, reply *bool) error {
+	var r CheckReply
+	err := t.CheckWithAttempts(args, &r)
+	*reply = r.OK
+	return err
 }
 
-func (t *TCN) UnmergeCell(args *CellArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xC9, byte(args.Number)), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+// CheckWithAttempts is Check with the tries of the command.
+func (t *TCN) CheckWithAttempts(args *BasicArgs, reply *CheckReply) error {
+	m, err := t.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	attempts := recordAttempts(m)
+	err = m.Check(context.Background())
+	*reply = CheckReply{
+		OK:       err == nil,
+		Attempts: *attempts,
+	}
+	return err
 }
 
-func (t *TCN) SetCellAsBelt(args *CellArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0x68, byte(args.Number)), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) MergeCell(args *CellArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.MergeCell(context.Background(), args.Number)
+	})
 }
 
-func (t *TCN) SetCellAsSpring(args *CellArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0x74, byte(args.Number)), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) UnmergeCell(args *CellArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.UnmergeCell(context.Background(), args.Number)
+	})
 }
 
-func (t *TCN) SetAllCellsAsBelt(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0x76, 0x55), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) SetCellAsBelt(args *CellArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.SetCellAsBelt(context.Background(), args.Number)
+	})
 }
 
-func (t *TCN) SetAllCellsAsSpring(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0x75, 0x55), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) SetCellAsSpring(args *CellArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.SetCellAsSpring(context.Background(), args.Number)
+	})
+}
+
+func (t *TCN) SetAllCellsAsBelt(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.SetAllCellsAsBelt(context.Background())
+	})
+}
+
+func (t *TCN) SetAllCellsAsSpring(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.SetAllCellsAsSpring(context.Background())
+	})
 }
 
 func (t *TCN) Status(args *BasicArgs, reply *StatusReply) error {
-	b, err := t.write(args.ClientID, t.bytes(0xDC, 0x55), tcn.KEY_DEFAULT, 1000)
+	m, err := t.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	attempts := recordAttempts(m)
+	status, err := m.Status(context.Background())
 	if err != nil {
 		return err
 	}
 	*reply = StatusReply{
-		Time:              time.Now(),
-		ActualTemperature: int(b[2]),
+		Time:              status.Time,
+		ActualTemperature: status.ActualTemperature,
+		Attempts:          *attempts,
 	}
 	return nil
 }
 
-func (t *TCN) Rotate(args *RotateArgs, reply *bool) (err error) {
-	var b []byte
-	b, err = t.write(args.ClientID, t.bytes(byte(args.Number), 0xAA), tcn.KEY_DEFAULT, args.Timeout)
-	if err == nil {
-		*reply = bytes.Equal(b, []byte{0x00, 0x5D, 0x00, 0xAA, 0x07})
-	}
-	return
+func (t *TCN) Rotate(args *RotateArgs, reply *bool) error {
+	var r RotateReply
+	err := t.RotateWithAttempts(args, &r)
+	*reply = r.Success
+	return err
 }
 
-func (t *TCN) RotateAll(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0x65, 0x55), tcn.KEY_DEFAULT, 3*60*1000)
-	*reply = err == nil
-	return
+// RotateWithAttempts is Rotate with the tries of the command. It shares the
+// request IDs of Rotate.
+func (t *TCN) RotateWithAttempts(args *RotateArgs, reply *RotateReply) error {
+	return t.idempotent("Rotate", args.ClientID, args.RequestID, args.Number, reply, func() error {
+		m, err := t.Machine(args.ClientID)
+		if err != nil {
+			return err
+		}
+		attempts := recordAttempts(m)
+		ctx, cancel := timeoutContext(args.Timeout)
+		defer cancel()
+		success, err := m.Rotate(ctx, args.Number)
+		*reply = RotateReply{
+			Success:  success,
+			Attempts: *attempts,
+		}
+		return err
+	})
 }
 
-func (t *TCN) TurnOnHeater(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xD4, 0x01), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) RotateAll(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.RotateAll(context.Background())
+	})
 }
 
-func (t *TCN) TurnOffHeater(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xD4, 0x00), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) TurnOnHeater(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOnHeater(context.Background())
+	})
 }
 
-func (t *TCN) TurnOnLights(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xDD, 0xAA), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) TurnOffHeater(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOffHeater(context.Background())
+	})
 }
 
-func (t *TCN) TurnOffLights(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xDD, 0x55), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) TurnOnLights(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOnLights(context.Background())
+	})
 }
 
-func (t *TCN) TurnOnRefrigerator(args *TurnOnRefrigeratorArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xCC, 0x01), tcn.KEY_DEFAULT, 1000)
-	if err == nil {
-		_, err = t.write(args.ClientID, t.bytes(0xCD, 0x01), tcn.KEY_DEFAULT, 1000)
-	}
-	if err == nil {
-		_, err = t.write(args.ClientID, t.bytes(0xCE, byte(args.Temperature)), tcn.KEY_DEFAULT, 1000)
-	}
-	return
+func (t *TCN) TurnOffLights(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOffLights(context.Background())
+	})
 }
 
-func (t *TCN) TurnOffRefrigerator(args *BasicArgs, reply *bool) (err error) {
-	_, err = t.write(args.ClientID, t.bytes(0xCC, 0x00), tcn.KEY_DEFAULT, 1000)
-	*reply = err == nil
-	return
+func (t *TCN) TurnOnRefrigerator(args *TurnOnRefrigeratorArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOnRefrigerator(context.Background(), args.Temperature)
+	})
 }
 
-func (t *TCN) bytes(primary byte, secondary byte) []byte {
-	return []byte{0x00, 0xFF, primary, primary ^ 0xFF, secondary, secondary ^ 0xFF}
+func (t *TCN) TurnOffRefrigerator(args *BasicArgs, reply *bool) error {
+	return t.do(args.ClientID, reply, func(m *tcn.Machine) error {
+		return m.TurnOffRefrigerator(context.Background())
+	})
 }
 
 func (t *TCN) LifterStatus(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_GET_STATUS, 0x00), tcn.KEY_STATUS, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
-}
-
-func (t *TCN) lifterEnsureOK(clientId string) (*LifterStatusReply, error) {
-	b, err := t.write(clientId, t.lifterBytes(tcn.FUNC_LIFTER_GET_STATUS, 0x00), tcn.KEY_STATUS, 1000)
-	if err != nil {
-		return nil, err
-	}
-	reply := lifterStatusReply(b)
-	if reply.OK {
-		return nil, nil
-	}
-	return &reply, nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterStatus(context.Background())
+	})
 }
 
 func (t *TCN) LifterShip(args *LifterShipArgs, reply *LifterStatusReply) error {
-	if r, err := t.lifterEnsureOK(args.ClientID); r != nil || err != nil {
-		if r != nil {
-			*reply = *r
-		}
-		return err
-	}
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_SHIP, 0x00, byte(args.Number), 0x00, 0x00), tcn.KEY_SHIP, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	tt := args.Timeout
-	if tt == 0 {
-		tt = 60000
-	} else if tt < 100 {
-		tt = 100
-	}
-	timeout := time.After(time.Duration(tt) * time.Millisecond)
-	tick := time.Tick(1000 * time.Millisecond)
-	defer func(hl bool) {
-		t.hideLogs = hl
-	}(t.hideLogs)
-	t.hideLogs = true
-	for {
-		select {
-		case <-timeout:
-			return ErrTimeout
-		case <-tick:
-			b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_GET_STATUS, 0x00), tcn.KEY_STATUS, 1000)
-			if err != nil {
-				return err
-			}
-			r := lifterStatusReply(b)
-			*reply = r
-			if r.OK { // success
-				return nil
-			}
-			if b[5] != 0x00 { // error
-				return nil
-			}
-		}
-	}
+	return t.idempotent("LifterShip", args.ClientID, args.RequestID, args.Number, reply, func() error {
+		return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+			ctx, cancel := timeoutContext(args.Timeout)
+			defer cancel()
+			return m.LifterShip(ctx, args.Number)
+		})
+	})
 }
 
 func (t *TCN) LifterOpenTray(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_OPERATE_TRAY, 0x00, 0x01), tcn.KEY_TRAY, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterOpenTray(context.Background())
+	})
 }
 
 func (t *TCN) LifterCloseTray(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_OPERATE_TRAY, 0x00, 0x02), tcn.KEY_TRAY, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterCloseTray(context.Background())
+	})
 }
 
 func (t *TCN) LifterMove(args *LifterMoveArgs, reply *LifterStatusReply) error {
-	n := args.Number
-	if n < 1 { // prevent "03" error
-		n = 1
-	}
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_MOVE_LIFTER, 0x00, byte(n)), tcn.KEY_MOVE, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterMove(context.Background(), args.Number)
+	})
 }
 
 func (t *TCN) LifterReset(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_RESET_LIFTER, 0x00, 0x00), tcn.KEY_RESET, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterReset(context.Background())
+	})
 }
 
 func (t *TCN) LifterOpenShutter(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_OPERATE_SHUTTER, 0x00, 0x00), tcn.KEY_SHUTTER, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterOpenShutter(context.Background())
+	})
 }
 
 func (t *TCN) LifterCloseShutter(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_OPERATE_SHUTTER, 0x00, 0x01), tcn.KEY_SHUTTER, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterCloseShutter(context.Background())
+	})
 }
 
 func (t *TCN) LifterClearErrors(args *BasicArgs, reply *LifterStatusReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_CLEAR_ERRORS, 0x00), tcn.KEY_CLEAR, 1000)
-	if err != nil {
-		return err
-	}
-	*reply = lifterStatusReply(b)
-	return nil
+	return t.doLifter(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterStatus, error) {
+		return m.LifterClearErrors(context.Background())
+	})
 }
 
 func (t *TCN) LifterCheckExistence(args *BasicArgs, reply *LifterExistenceReply) error {
-	b, err := t.write(args.ClientID, t.lifterBytes(tcn.FUNC_LIFTER_CHECK_EXISTENCE, 0x00), tcn.KEY_EXIST, 1000)
+	m, err := t.Machine(args.ClientID)
 	if err != nil {
 		return err
 	}
-	var exists *bool
-	if b[4] == 0x01 {
-		e := true
-		exists = &e
-	} else if b[4] == 0x00 {
-		e := false
-		exists = &e
+	existence, err := m.LifterCheckExistence(context.Background())
+	if err != nil {
+		return err
 	}
 	*reply = LifterExistenceReply{
-		Bytes:  b,
-		Exists: exists,
+		Bytes:  existence.Bytes,
+		Exists: existence.Exists,
 	}
 	return nil
 }
 
-// try this function in The Go Playground: https://play.golang.org/p/f_ZD-i5GsEy
-func (t *TCN) lifterBytes(function byte, data ...byte) (out []byte) {
-	fnd := append([]byte{function}, data...)
-	fnd = append(fnd, sum(data))
-	out = append(out, 0x02, byte(len(fnd)))
-	out = append(out, fnd...)
-	out = append(out, 0x03)
-	out = append(out, xor(out))
-	return
+// LifterQueryParameters returns the raw value of a parameter index.
+func (t *TCN) LifterQueryParameters(args *LifterQueryParametersArgs, reply *LifterParameterReply) error {
+	var value int
+	err := t.doLifterQuery(args.ClientID, &reply.LifterDataReply, func(m *tcn.Machine) (tcn.LifterData, error) {
+		data, err := m.LifterQueryParameters(context.Background(), args.Index)
+		value = data.Value()
+		return data, err
+	})
+	if err != nil {
+		return err
+	}
+	reply.Index = args.Index
+	reply.Value = value
+	return nil
 }
 
-func lifterStatusReply(bytes []byte) LifterStatusReply {
-	statusByte := bytes[4]
-	statusCode := fmt.Sprintf("%02d", statusByte)
-	errorByte := bytes[5] // 6th byte is error byte if size byte is '05'
-	errorCode := fmt.Sprintf("%02d", errorByte)
-	switch errorByte {
-	case 11, 12, 13, 14, 15, 16, 17, 18, 19:
-		errorCode = "10i"
-	case 21, 22, 23, 24, 25, 26, 27, 28, 29:
-		errorCode = "20i"
-	}
-	return LifterStatusReply{
-		Bytes:      bytes,
-		StatusCode: statusCode,
-		ErrorCode:  errorCode,
-		OK:         statusByte == 0x00 && errorByte == 0x00,
-	}
+func (t *TCN) LifterQueryDriverCommand(args *BasicArgs, reply *LifterDataReply) error {
+	return t.doLifterQuery(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterData, error) {
+		return m.LifterQueryDriverCommand(context.Background())
+	})
+}
+
+func (t *TCN) LifterDetectLight(args *BasicArgs, reply *LifterDataReply) error {
+	return t.doLifterQuery(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterData, error) {
+		return m.LifterDetectLight(context.Background())
+	})
+}
+
+func (t *TCN) LifterDetectSwitchInput(args *BasicArgs, reply *LifterDataReply) error {
+	return t.doLifterQuery(args.ClientID, reply, func(m *tcn.Machine) (tcn.LifterData, error) {
+		return m.LifterDetectSwitchInput(context.Background())
+	})
 }
 
-func sum(data []byte) (out byte) {
-	for i := 0; i < len(data); i++ {
-		out += data[i]
+// do runs a command that only reports whether it has succeeded.
+func (t *TCN) do(clientId string, reply *bool, command func(*tcn.Machine) error) error {
+	m, err := t.Machine(clientId)
+	if err == nil {
+		err = command(m)
 	}
-	return
+	*reply = err == nil
+	return err
 }
 
-func xor(data []byte) (out byte) {
-	for i := 0; i < len(data); i++ {
-		out ^= data[i]
+func (t *TCN) doLifter(clientId string, reply *LifterStatusReply, command func(*tcn.Machine) (tcn.LifterStatus, error)) error {
+	m, err := t.Machine(clientId)
+	if err != nil {
+		return err
 	}
-	return
+	attempts := recordAttempts(m)
+	status, err := command(m)
+	if status.Bytes != nil {
+		*reply = lifterStatusReply(status)
+		reply.Attempts = *attempts
+	}
+	return err
 }
 
-func (t *TCN) write(clientId string, input []byte, channelKey string, timeout int) (output []byte, err error) {
-	if len(input) == 0 {
-		err = ErrNoContent
-		return
+func (t *TCN) doLifterQuery(clientId string, reply *LifterDataReply, command func(*tcn.Machine) (tcn.LifterData, error)) error {
+	m, err := t.Machine(clientId)
+	if err != nil {
+		return err
 	}
-	if t.Clients == nil {
-		err = ErrNoSuchClient
-		return
+	data, err := command(m)
+	if err != nil {
+		return err
 	}
-	_client, ok := t.Clients.Load(clientId)
-	if !ok {
-		err = ErrNoSuchClient
-		return
+	*reply = LifterDataReply{
+		Bytes: data.Bytes,
+		Data:  data.Data,
 	}
-	client, ok := _client.(Client)
-	if !ok {
-		err = ErrNoSuchClient
-		return
-	}
-	channels := client.GetChannels()
-	channel, hasChannel := channels.LoadOrStore(channelKey, make(chan []byte))
-	if hasChannel {
-		err = ErrProcessing
-		return
-	} else {
-		defer channels.Delete(channelKey)
-	}
-	var n int
-	n, err = client.Write(input)
-	if t.hideLogs == false {
-		if clientId == "" {
-			log.Printf("%d bytes written: % X", n, input)
-		} else {
-			log.Printf("%s %d bytes written: % X", clientId, n, input)
-		}
+	return nil
+}
+
+func lifterStatusReply(status tcn.LifterStatus) LifterStatusReply {
+	reply := LifterStatusReply{
+		Bytes:      status.Bytes,
+		OK:         status.OK,
+		StatusCode: status.StatusCode,
+		ErrorCode:  status.ErrorCode,
 	}
-	if err == nil {
-		timeoutChan := newTimeoutChan(timeout)
-		for {
-			select {
-			case output = <-channel.(chan []byte):
-				return
-			case <-timeoutChan:
-				err = ErrTimeout
-				return
-			}
-		}
-	} else {
-		log.Println("error writting", input, err)
+	if status.Error != tcn.LIFTER_ERROR_NONE {
+		e := status.Error
+		reply.Error = &e
 	}
-	return
+	return reply
 }
 
-func newTimeoutChan(t int) <-chan time.Time {
+// timeoutContext returns a context that expires after t milliseconds, or
+// one without deadline if t is 0 so that the default timeout of the command
+// applies.
+func timeoutContext(t int) (context.Context, context.CancelFunc) {
 	if t == 0 {
-		t = 10000
+		return context.WithCancel(context.Background())
 	} else if t < 100 {
 		t = 100
 	}
-	timeout := time.Duration(t) * time.Millisecond
-	return time.After(timeout)
+	return context.WithTimeout(context.Background(), time.Duration(t)*time.Millisecond)
+}
+
+// recordAttempts appends every try of the commands run by m to the returned
+// slice.
+func recordAttempts(m *tcn.Machine) *[]AttemptReply {
+	attempts := &[]AttemptReply{}
+	m.OnAttempt = func(attempt tcn.Attempt) {
+		reply := AttemptReply{
+			Command:  attempt.Command,
+			Time:     attempt.Time,
+			Duration: attempt.Duration,
+		}
+		if attempt.Error != nil {
+			reply.Error = attempt.Error.Error()
+		}
+		*attempts = append(*attempts, reply)
+	}
+	return attempts
 }
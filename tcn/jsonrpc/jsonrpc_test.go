@@ -0,0 +1,93 @@
+package jsonrpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/fault"
+	"github.com/caiguanhao/vending-processors/idempotency"
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func TestLifterQueryParameters(t *testing.T) {
+	s, board := newTCN()
+	board.SetParameter(1, 5)
+	var reply LifterParameterReply
+	if err := s.LifterQueryParameters(&LifterQueryParametersArgs{BasicArgs{"a"}, 1}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.Index != 1 || reply.Value != 5 || len(reply.Data) < 4 || reply.Data[3] != 5 {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestRotateRequestID(t *testing.T) {
+	s, board := newTCN()
+	board.SetStock(3, 5)
+	args := &RotateArgs{BasicArgs: BasicArgs{"a"}, Number: 3, RequestID: "r1"}
+	for i := 0; i < 2; i++ {
+		var ok bool
+		if err := s.Rotate(args, &ok); err != nil || !ok {
+			t.Fatalf("ok = %v, err = %v", ok, err)
+		}
+	}
+	// the reply with the tries is kept for the same request ID
+	var reply RotateReply
+	if err := s.RotateWithAttempts(args, &reply); err != nil || !reply.Success || len(reply.Attempts) != 1 {
+		t.Fatalf("reply = %+v, err = %v", reply, err)
+	}
+	if n := board.Stock(3); n != 4 {
+		t.Errorf("stock = %d, want 4", n)
+	}
+	var ok bool
+	other := &RotateArgs{BasicArgs: BasicArgs{"a"}, Number: 4, RequestID: "r1"}
+	if err := s.Rotate(other, &ok); err != idempotency.ErrMismatch {
+		t.Errorf("err = %v, want ErrMismatch", err)
+	}
+	other.RequestID = "r2"
+	if err := s.Rotate(other, &ok); err != nil || !ok {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	board := sim.NewBoard()
+	board.RotateDuration = 10 * time.Millisecond
+	board.SetStock(3, 5)
+	// the first two commands are lost on the way to the board
+	client := &fault.Client{
+		Board: board,
+		Injector: fault.NewInjector(fault.Script{Rules: []fault.Rule{
+			{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DROP, Times: 2},
+		}}),
+	}
+	clients := &sync.Map{}
+	clients.Store("a", client)
+	retry := tcn.Retry{Attempts: 3, Backoff: 10, Timeout: 200}
+	s := &TCN{Clients: clients, Retry: &tcn.RetryPolicy{Read: retry, Motor: retry}}
+
+	var check CheckReply
+	if err := s.CheckWithAttempts(&BasicArgs{"a"}, &check); err != nil || !check.OK || len(check.Attempts) != 3 {
+		t.Fatalf("check = %+v, err = %v", check, err)
+	}
+	if a := check.Attempts[0]; a.Command != "check" || a.Error != tcn.ErrTimeout.Error() || check.Attempts[2].Error != "" {
+		t.Errorf("check attempts = %+v", check.Attempts)
+	}
+
+	// a rotate that timed out may have run, so it is not retried
+	client.Injector = fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DROP, Times: 1},
+	}})
+	var rotate RotateReply
+	if err := s.RotateWithAttempts(&RotateArgs{BasicArgs: BasicArgs{"a"}, Number: 3}, &rotate); err != tcn.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	if len(rotate.Attempts) != 1 || rotate.Attempts[0].Command != "rotate" || board.Stock(3) != 5 {
+		t.Errorf("rotate = %+v, stock = %d", rotate, board.Stock(3))
+	}
+	if err := s.RotateWithAttempts(&RotateArgs{BasicArgs: BasicArgs{"a"}, Number: 3}, &rotate); err != nil || !rotate.Success || len(rotate.Attempts) != 1 {
+		t.Errorf("rotate = %+v, err = %v", rotate, err)
+	}
+}
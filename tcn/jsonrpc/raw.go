@@ -0,0 +1,83 @@
+package jsonrpc
+
+import (
+	"errors"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+var (
+	ErrExpertMode = errors.New("expert mode is off")
+	ErrNoInput    = errors.New("hex or function is required")
+)
+
+type (
+	SendRawArgs struct {
+		BasicArgs
+		// frame to send as is
+		Hex Hex `json:"hex"`
+		// if hex is empty, the frame is built from function and data: a
+		// lifter frame if lifter is true, otherwise a basic command with
+		// function as the primary and the first byte of data as the
+		// secondary byte
+		Lifter   bool `json:"lifter"`
+		Function int  `json:"function"`
+		Data     Hex  `json:"data"`
+		// channel key of the reply, defaults to the key of the function
+		// (see tcn.FunctionKey) for lifter frames and "default" for basic
+		// commands
+		Key string `json:"key"`
+		// milliseconds, defaults to 10000
+		Timeout int `json:"timeout"`
+	}
+
+	SendRawReply struct {
+		Bytes Hex `json:"bytes"`
+	}
+)
+
+// SendRaw sends any frame and returns the raw reply. It is only available
+// when ExpertMode is on, as a wrong command may damage the machine.
+func (t *TCN) SendRaw(args *SendRawArgs, reply *SendRawReply) error {
+	if !t.ExpertMode {
+		return ErrExpertMode
+	}
+	m, err := t.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	input := []byte(args.Hex)
+	key := args.Key
+	switch {
+	case len(input) > 0:
+		if key == "" && len(input) > 2 && input[0] == 0x02 {
+			key = tcn.FunctionKey(input[2])
+		}
+	case args.Function != 0 && args.Lifter:
+		input = tcn.LifterBytes(byte(args.Function), args.Data...)
+		if key == "" {
+			key = tcn.FunctionKey(byte(args.Function))
+		}
+	case args.Function != 0:
+		var secondary byte
+		if len(args.Data) > 0 {
+			secondary = args.Data[0]
+		}
+		input = tcn.BasicBytes(byte(args.Function), secondary)
+	default:
+		return ErrNoInput
+	}
+	if key == "" {
+		key = tcn.KEY_DEFAULT
+	}
+	ctx, cancel := timeoutContext(args.Timeout)
+	defer cancel()
+	output, err := m.SendRaw(ctx, input, key)
+	if err != nil {
+		return err
+	}
+	*reply = SendRawReply{
+		Bytes: output,
+	}
+	return nil
+}
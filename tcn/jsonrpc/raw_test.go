@@ -0,0 +1,48 @@
+package jsonrpc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+func TestSendRaw(t *testing.T) {
+	s, board := newTCN()
+	var reply SendRawReply
+	if err := s.SendRaw(&SendRawArgs{BasicArgs: BasicArgs{"a"}, Function: 0xDC}, &reply); err != ErrExpertMode {
+		t.Errorf("err = %v, want ErrExpertMode", err)
+	}
+	s.ExpertMode = true
+	if err := s.SendRaw(&SendRawArgs{BasicArgs: BasicArgs{"a"}}, &reply); err != ErrNoInput {
+		t.Errorf("err = %v, want ErrNoInput", err)
+	}
+
+	board.SetTemperature(12)
+	if err := s.SendRaw(&SendRawArgs{BasicArgs: BasicArgs{"a"}, Function: 0xDC}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x00, 0x5D, 12, 0x00, 0x69}; !bytes.Equal(reply.Bytes, want) {
+		t.Errorf("basic = % X, want % X", []byte(reply.Bytes), want)
+	}
+
+	board.SetLifterError(tcn.LIFTER_ERROR_DOOR_NOT_CLOSED)
+	if err := s.SendRaw(&SendRawArgs{BasicArgs: BasicArgs{"a"}, Lifter: true, Function: tcn.FUNC_LIFTER_GET_STATUS, Data: Hex{0x00}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if status := tcn.ParseLifterStatus(reply.Bytes); status.Error != tcn.LIFTER_ERROR_DOOR_NOT_CLOSED {
+		t.Errorf("lifter = % X", []byte(reply.Bytes))
+	}
+
+	// a frame as is, with the key from its function
+	var args SendRawArgs
+	frame := tcn.LifterBytes(tcn.FUNC_LIFTER_CHECK_EXISTENCE, 0x00)
+	if err := json.Unmarshal([]byte(`{"client_id":"a","hex":"`+hex.EncodeToString(frame)+`"}`), &args); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SendRaw(&args, &reply); err != nil || reply.Bytes[2] != tcn.FUNC_LIFTER_CHECK_EXISTENCE {
+		t.Errorf("hex: reply = % X, err = %v", []byte(reply.Bytes), err)
+	}
+}
@@ -0,0 +1,177 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+type (
+	LifterShipProgressArgs struct {
+		BasicArgs
+		// number of events already received, only newer events are returned
+		Since int `json:"since"`
+	}
+
+	LifterShipProgressReply struct {
+		Events []LifterProgressReply `json:"events"`
+		// value of since for the next call
+		Next  int                `json:"next"`
+		Done  bool               `json:"done"`
+		Error string             `json:"error"`
+		Final *LifterStatusReply `json:"final"`
+	}
+
+	LifterProgressReply struct {
+		Time   time.Time         `json:"time"`
+		Stage  string            `json:"stage"`
+		Status LifterStatusReply `json:"status"`
+	}
+
+	lifterShip struct {
+		cancel context.CancelFunc
+
+		mu       sync.Mutex
+		events   []LifterProgressReply
+		done     bool
+		finished time.Time
+		err      error
+		final    *LifterStatusReply
+	}
+)
+
+// LifterShipStart starts LifterShip in the background, use LifterShipProgress
+// to follow it and LifterShipAbort to stop waiting for it.
+func (t *TCN) LifterShipStart(args *LifterShipArgs, reply *bool) error {
+	return t.idempotent("LifterShipStart", args.ClientID, args.RequestID, args.Number, reply, func() error {
+		return t.lifterShipStart(args, reply)
+	})
+}
+
+func (t *TCN) lifterShipStart(args *LifterShipArgs, reply *bool) error {
+	m, err := t.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	t.shipsMu.Lock()
+	defer t.shipsMu.Unlock()
+	t.expireShips()
+	if previous, ok := t.ships[args.ClientID]; ok && !previous.isDone() {
+		return ErrProcessing
+	}
+	if t.ships == nil {
+		t.ships = map[string]*lifterShip{}
+	}
+	ship := &lifterShip{}
+	var ctx context.Context
+	ctx, ship.cancel = timeoutContext(args.Timeout)
+	t.ships[args.ClientID] = ship
+	go func() {
+		defer ship.cancel()
+		status, err := m.LifterShipWithProgress(ctx, args.Number, ship.progress)
+		ship.finish(status, err)
+	}()
+	*reply = true
+	return nil
+}
+
+// LifterShipProgress returns the progress of the lifter started by
+// LifterShipStart. Once it is done, the result is kept for ShipResultTTL,
+// after which calls fail with ErrNoContent.
+func (t *TCN) LifterShipProgress(args *LifterShipProgressArgs, reply *LifterShipProgressReply) error {
+	ship, err := t.ship(args.ClientID)
+	if err != nil {
+		return err
+	}
+	*reply = ship.since(args.Since)
+	return nil
+}
+
+// LifterShipAbort stops waiting for the lifter started by LifterShipStart.
+func (t *TCN) LifterShipAbort(args *BasicArgs, reply *bool) error {
+	ship, err := t.ship(args.ClientID)
+	if err != nil {
+		return err
+	}
+	ship.cancel()
+	*reply = true
+	return nil
+}
+
+// ship returns the lifter ship of the client, ErrNoContent if there is none.
+func (t *TCN) ship(clientId string) (*lifterShip, error) {
+	t.shipsMu.Lock()
+	t.expireShips()
+	ship, ok := t.ships[clientId]
+	t.shipsMu.Unlock()
+	if ok {
+		return ship, nil
+	}
+	if _, err := t.Machine(clientId); err != nil {
+		return nil, err
+	}
+	return nil, ErrNoContent
+}
+
+// expireShips removes the ships that finished more than ShipResultTTL ago,
+// the lock is held.
+func (t *TCN) expireShips() {
+	ttl := t.ShipResultTTL
+	if ttl == 0 {
+		ttl = 600000
+	}
+	expired := time.Now().Add(-time.Duration(ttl) * time.Millisecond)
+	for clientId, ship := range t.ships {
+		ship.mu.Lock()
+		if ship.done && ship.finished.Before(expired) {
+			delete(t.ships, clientId)
+		}
+		ship.mu.Unlock()
+	}
+}
+
+func (s *lifterShip) progress(p tcn.LifterProgress) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, LifterProgressReply{
+		Time:   p.Time,
+		Stage:  p.Stage,
+		Status: lifterStatusReply(p.Status),
+	})
+}
+
+func (s *lifterShip) finish(status tcn.LifterStatus, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.done = true
+	s.finished = time.Now()
+	s.err = err
+	if status.Bytes != nil {
+		final := lifterStatusReply(status)
+		s.final = &final
+	}
+}
+
+func (s *lifterShip) isDone() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.done
+}
+
+func (s *lifterShip) since(n int) (reply LifterShipProgressReply) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n < 0 || n > len(s.events) {
+		n = len(s.events)
+	}
+	reply.Events = append([]LifterProgressReply{}, s.events[n:]...)
+	reply.Next = len(s.events)
+	reply.Done = s.done
+	if s.err != nil {
+		reply.Error = s.err.Error()
+	}
+	reply.Final = s.final
+	return
+}
@@ -0,0 +1,98 @@
+package jsonrpc
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func newTCN() (*TCN, *sim.Board) {
+	board := sim.NewBoard()
+	board.RotateDuration = 50 * time.Millisecond
+	board.ShipDuration = 100 * time.Millisecond
+	clients := &sync.Map{}
+	clients.Store("a", board)
+	return &TCN{Clients: clients}, board
+}
+
+func TestLifterShipProgress(t *testing.T) {
+	s, _ := newTCN()
+	var started bool
+	if err := s.LifterShipStart(&LifterShipArgs{BasicArgs: BasicArgs{"a"}, Number: 1}, &started); err != nil || !started {
+		t.Fatalf("started = %v, err = %v", started, err)
+	}
+	if err := s.LifterShipStart(&LifterShipArgs{BasicArgs: BasicArgs{"a"}, Number: 2}, &started); err != ErrProcessing {
+		t.Errorf("err = %v, want ErrProcessing", err)
+	}
+	var stages []string
+	next := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var reply LifterShipProgressReply
+		if err := s.LifterShipProgress(&LifterShipProgressArgs{BasicArgs{"a"}, next}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range reply.Events {
+			stages = append(stages, event.Stage)
+		}
+		next = reply.Next
+		if reply.Done {
+			if reply.Error != "" || reply.Final == nil || !reply.Final.OK {
+				t.Errorf("final = %+v, error = %s", reply.Final, reply.Error)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if len(stages) < 3 || stages[0] != tcn.LIFTER_STAGE_CHECKING || stages[len(stages)-1] != tcn.LIFTER_STAGE_DONE {
+		t.Errorf("stages = %v", stages)
+	}
+	// kept for a retry after a lost reply, then forgotten
+	var reply LifterShipProgressReply
+	if err := s.LifterShipProgress(&LifterShipProgressArgs{BasicArgs{"a"}, next}, &reply); err != nil || !reply.Done || reply.Final == nil {
+		t.Errorf("reply = %+v, err = %v", reply, err)
+	}
+	s.ShipResultTTL = 1
+	time.Sleep(5 * time.Millisecond)
+	if err := s.LifterShipProgress(&LifterShipProgressArgs{BasicArgs{"a"}, 0}, &reply); err != ErrNoContent {
+		t.Errorf("err = %v, want ErrNoContent", err)
+	}
+	if err := s.LifterShipProgress(&LifterShipProgressArgs{BasicArgs{"b"}, 0}, &reply); err != ErrNoSuchClient {
+		t.Errorf("err = %v, want ErrNoSuchClient", err)
+	}
+}
+
+func TestLifterShipAbort(t *testing.T) {
+	s, board := newTCN()
+	board.ShipDuration = 5 * time.Second
+	var ok bool
+	if err := s.LifterShipStart(&LifterShipArgs{BasicArgs: BasicArgs{"a"}, Number: 1}, &ok); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.LifterShipAbort(&BasicArgs{"a"}, &ok); err != nil || !ok {
+		t.Fatalf("ok = %v, err = %v", ok, err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var reply LifterShipProgressReply
+		if err := s.LifterShipProgress(&LifterShipProgressArgs{BasicArgs{"a"}, 0}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Done {
+			if reply.Error == "" {
+				t.Error("error is empty, want the cancellation")
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
@@ -0,0 +1,73 @@
+package jsonrpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+var ErrNoSlotHealth = errors.New("slot health is not tracked")
+
+type (
+	SlotsReply struct {
+		Slots []SlotReply `json:"slots"`
+	}
+
+	SlotReply struct {
+		Number              int `json:"number"`
+		Attempts            int `json:"attempts"`
+		Failures            int `json:"failures"`
+		ConsecutiveFailures int `json:"consecutive_failures"`
+		// see tcn.SlotStats
+		AverageDuration float64   `json:"average_duration"`
+		LastErrorCode   string    `json:"last_error_code"`
+		LastAttemptAt   time.Time `json:"last_attempt_at"`
+		Disabled        bool      `json:"disabled"`
+		DisabledAt      time.Time `json:"disabled_at"`
+	}
+)
+
+// Slots returns the statistics of the slots of the client that have been
+// rotated or shipped, see TCN.SlotHealth.
+func (t *TCN) Slots(args *BasicArgs, reply *SlotsReply) error {
+	if t.SlotHealth == nil {
+		return ErrNoSlotHealth
+	}
+	slots := []SlotReply{}
+	for _, stats := range t.SlotHealth.Stats(args.ClientID) {
+		slots = append(slots, slotReply(stats))
+	}
+	*reply = SlotsReply{
+		Slots: slots,
+	}
+	return nil
+}
+
+// EnableSlot enables the slot that has been disabled after too many
+// failures. It fails with tcn.ErrSlotNotTracked if the slot has not been
+// rotated or shipped.
+func (t *TCN) EnableSlot(args *CellArgs, reply *bool) error {
+	if t.SlotHealth == nil {
+		return ErrNoSlotHealth
+	}
+	if err := t.SlotHealth.Enable(args.ClientID, args.Number); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func slotReply(stats tcn.SlotStats) SlotReply {
+	return SlotReply{
+		Number:              stats.Number,
+		Attempts:            stats.Attempts,
+		Failures:            stats.Failures,
+		ConsecutiveFailures: stats.ConsecutiveFailures,
+		AverageDuration:     stats.AverageDuration,
+		LastErrorCode:       stats.LastErrorCode,
+		LastAttemptAt:       stats.LastAttemptAt,
+		Disabled:            stats.Disabled,
+		DisabledAt:          stats.DisabledAt,
+	}
+}
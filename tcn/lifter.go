@@ -0,0 +1,287 @@
+package tcn
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	LIFTER_STAGE_CHECKING = "checking" // lifter status before shipping
+	LIFTER_STAGE_STARTED  = "started"  // ship command accepted
+	LIFTER_STAGE_SHIPPING = "shipping" // lifter is busy
+	LIFTER_STAGE_DONE     = "done"
+	LIFTER_STAGE_FAILED   = "failed"
+)
+
+type (
+	LifterProgress struct {
+		Time   time.Time
+		Stage  string
+		Status LifterStatus
+	}
+
+	LifterStatus struct {
+		Bytes      []byte
+		OK         bool
+		StatusCode string
+		ErrorCode  string
+		Error      LifterError
+	}
+
+	LifterExistence struct {
+		Bytes  []byte
+		Exists *bool
+	}
+
+	// LifterData is the reply of a query command.
+	LifterData struct {
+		Bytes []byte
+		// bytes between the function code and the checksum
+		Data []byte
+	}
+)
+
+func (m *Machine) LifterStatus(ctx context.Context) (status LifterStatus, err error) {
+	err = m.retry(ctx, RETRY_READ, "lifter_status", func(ctx context.Context) (err error) {
+		status, err = m.lifterCommand(ctx, KEY_STATUS, FUNC_LIFTER_GET_STATUS, 0x00)
+		return noResponse(status, err)
+	})
+	if err == LIFTER_ERROR_NO_RESPONSE {
+		// the error is in the status
+		err = nil
+	}
+	return
+}
+
+// LifterShip ships the product in the slot once the lifter is ready and
+// polls the lifter status every second until shipping succeeds or fails.
+// A lifter that is not ready is returned as is. It waits 60 seconds if ctx
+// has no deadline.
+func (m *Machine) LifterShip(ctx context.Context, number int) (LifterStatus, error) {
+	return m.LifterShipWithProgress(ctx, number, nil)
+}
+
+// LifterShipWithProgress is like LifterShip but calls progress with every
+// status it gets. Cancelling ctx stops waiting for the lifter, but the lifter
+// keeps going until its current cycle is over.
+func (m *Machine) LifterShipWithProgress(ctx context.Context, number int, progress func(LifterProgress)) (status LifterStatus, err error) {
+	if m.SlotHealth != nil {
+		var release func()
+		if release, err = m.SlotHealth.reserve(ctx, m.ID, number); err != nil {
+			return
+		}
+		defer release()
+	}
+	report := func(stage string, status LifterStatus) {
+		if progress != nil {
+			progress(LifterProgress{
+				Time:   time.Now(),
+				Stage:  stage,
+				Status: status,
+			})
+		}
+	}
+	status, err = m.LifterStatus(ctx)
+	if err != nil {
+		return status, err
+	}
+	report(LIFTER_STAGE_CHECKING, status)
+	if !status.OK {
+		return status, nil
+	}
+	start := time.Now()
+	defer func() {
+		m.recordSlot(number, start, err, status.OK, status.ErrorCode)
+	}()
+	err = m.retry(ctx, RETRY_MOTOR, "lifter_ship", func(ctx context.Context) (err error) {
+		status, err = m.lifterCommand(ctx, KEY_SHIP, FUNC_LIFTER_SHIP, 0x00, byte(number), 0x00, 0x00)
+		return noResponse(status, err)
+	})
+	if err == LIFTER_ERROR_NO_RESPONSE {
+		err = nil
+	}
+	if err != nil {
+		return status, err
+	}
+	report(LIFTER_STAGE_STARTED, status)
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+	}
+	quiet := *m
+	quiet.HideLogs = true
+	quiet.OnAttempt = nil
+	ticker := time.NewTicker(1000 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status, contextError(ctx)
+		case <-ticker.C:
+			status, err = quiet.LifterStatus(ctx)
+			if err != nil {
+				return status, err
+			}
+			if status.OK { // success
+				report(LIFTER_STAGE_DONE, status)
+				return status, nil
+			}
+			if status.Bytes[5] != 0x00 { // error
+				report(LIFTER_STAGE_FAILED, status)
+				return status, nil
+			}
+			report(LIFTER_STAGE_SHIPPING, status)
+		}
+	}
+}
+
+func (m *Machine) LifterOpenTray(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_TRAY, FUNC_LIFTER_OPERATE_TRAY, 0x00, 0x01)
+}
+
+func (m *Machine) LifterCloseTray(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_TRAY, FUNC_LIFTER_OPERATE_TRAY, 0x00, 0x02)
+}
+
+func (m *Machine) LifterMove(ctx context.Context, number int) (LifterStatus, error) {
+	if number < 1 { // prevent "03" error
+		number = 1
+	}
+	return m.lifterCommand(ctx, KEY_MOVE, FUNC_LIFTER_MOVE_LIFTER, 0x00, byte(number))
+}
+
+func (m *Machine) LifterReset(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_RESET, FUNC_LIFTER_RESET_LIFTER, 0x00, 0x00)
+}
+
+func (m *Machine) LifterOpenShutter(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_SHUTTER, FUNC_LIFTER_OPERATE_SHUTTER, 0x00, 0x00)
+}
+
+func (m *Machine) LifterCloseShutter(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_SHUTTER, FUNC_LIFTER_OPERATE_SHUTTER, 0x00, 0x01)
+}
+
+func (m *Machine) LifterClearErrors(ctx context.Context) (LifterStatus, error) {
+	return m.lifterCommand(ctx, KEY_CLEAR, FUNC_LIFTER_CLEAR_ERRORS, 0x00)
+}
+
+func (m *Machine) LifterCheckExistence(ctx context.Context) (LifterExistence, error) {
+	b, err := m.write(ctx, LifterBytes(FUNC_LIFTER_CHECK_EXISTENCE, 0x00), KEY_EXIST, 1000)
+	if err != nil {
+		return LifterExistence{}, err
+	}
+	var exists *bool
+	if b[4] == 0x01 {
+		e := true
+		exists = &e
+	} else if b[4] == 0x00 {
+		e := false
+		exists = &e
+	}
+	return LifterExistence{
+		Bytes:  b,
+		Exists: exists,
+	}, nil
+}
+
+// see README.md for the data bytes of the following commands
+
+func (m *Machine) LifterQueryParameters(ctx context.Context, index int) (LifterData, error) {
+	return m.lifterQuery(ctx, KEY_GET_PARAMS, FUNC_LIFTER_GET_PARAMETERS, 0x00, byte(index))
+}
+
+func (m *Machine) LifterQueryDriverCommand(ctx context.Context) (LifterData, error) {
+	return m.lifterQuery(ctx, KEY_DRIVER, FUNC_LIFTER_GET_DRIVER, 0x00)
+}
+
+func (m *Machine) LifterDetectLight(ctx context.Context) (LifterData, error) {
+	return m.lifterQuery(ctx, KEY_LIGHT, FUNC_LIFTER_DETECT_LIGHT, 0x00)
+}
+
+func (m *Machine) LifterDetectSwitchInput(ctx context.Context) (LifterData, error) {
+	return m.lifterQuery(ctx, KEY_INPUT, FUNC_LIFTER_DETECT_INPUT, 0x00)
+}
+
+func (m *Machine) lifterQuery(ctx context.Context, key string, function byte, data ...byte) (LifterData, error) {
+	b, err := m.write(ctx, LifterBytes(function, data...), key, 1000)
+	if err != nil {
+		return LifterData{}, err
+	}
+	return ParseLifterData(b), nil
+}
+
+func (m *Machine) lifterCommand(ctx context.Context, key string, function byte, data ...byte) (LifterStatus, error) {
+	b, err := m.write(ctx, LifterBytes(function, data...), key, 1000)
+	if err != nil {
+		return LifterStatus{}, err
+	}
+	return ParseLifterStatus(b), nil
+}
+
+// noResponse returns LIFTER_ERROR_NO_RESPONSE if the lifter reports that its
+// driver board does not respond, so that the command can be retried.
+func noResponse(status LifterStatus, err error) error {
+	if err == nil && status.Error == LIFTER_ERROR_NO_RESPONSE {
+		return status.Error
+	}
+	return err
+}
+
+func ParseLifterStatus(bytes []byte) LifterStatus {
+	statusByte := bytes[4]
+	statusCode := fmt.Sprintf("%02d", statusByte)
+	errorByte := bytes[5] // 6th byte is error byte if size byte is '05'
+	return LifterStatus{
+		Bytes:      bytes,
+		StatusCode: statusCode,
+		ErrorCode:  LifterError(errorByte).Code(),
+		Error:      LifterError(errorByte),
+		OK:         statusByte == 0x00 && errorByte == 0x00,
+	}
+}
+
+func ParseLifterData(bytes []byte) LifterData {
+	return LifterData{
+		Bytes: bytes,
+		Data:  bytes[3 : len(bytes)-3],
+	}
+}
+
+// Value returns the last two data bytes of a CMD_QUERY_PARAMETERS reply as a
+// big-endian number. The meaning of each index is not confirmed, see README.md.
+func (d LifterData) Value() int {
+	if len(d.Data) < 2 {
+		return 0
+	}
+	return int(d.Data[len(d.Data)-2])<<8 | int(d.Data[len(d.Data)-1])
+}
+
+// LifterBytes returns the 0x02 ... 0x03 lifter frame, which is used for both
+// commands and replies.
+// try this function in The Go Playground: https://play.golang.org/p/f_ZD-i5GsEy
+func LifterBytes(function byte, data ...byte) (out []byte) {
+	fnd := append([]byte{function}, data...)
+	fnd = append(fnd, sum(data))
+	out = append(out, 0x02, byte(len(fnd)))
+	out = append(out, fnd...)
+	out = append(out, 0x03)
+	out = append(out, xor(out))
+	return
+}
+
+func sum(data []byte) (out byte) {
+	for i := 0; i < len(data); i++ {
+		out += data[i]
+	}
+	return
+}
+
+func xor(data []byte) (out byte) {
+	for i := 0; i < len(data); i++ {
+		out ^= data[i]
+	}
+	return
+}
@@ -0,0 +1,223 @@
+package tcn
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	SEVERITY_WARNING  = "warning"  // usually goes away by itself or after the door is closed
+	SEVERITY_ERROR    = "error"    // the current operation failed
+	SEVERITY_CRITICAL = "critical" // hardware fault, the lifter should not be used
+)
+
+// see README.md for the "official" error messages
+const (
+	LIFTER_ERROR_NONE                 LifterError = 0
+	LIFTER_ERROR_LOCK_SWITCH_ON_LOCK  LifterError = 1
+	LIFTER_ERROR_DOOR_SWITCH_ON_LOCK  LifterError = 2
+	LIFTER_ERROR_LIFT_OVERCURRENT     LifterError = 3
+	LIFTER_ERROR_STEPS_OVER_LIMIT     LifterError = 4
+	LIFTER_ERROR_TOO_FEW_LAYERS       LifterError = 5
+	LIFTER_ERROR_HOME_TIMEOUT         LifterError = 6
+	LIFTER_ERROR_RUN_TIMEOUT          LifterError = 7
+	LIFTER_ERROR_FALL_TIMEOUT         LifterError = 8
+	LIFTER_ERROR_LOCK_SWITCH_ON_OPEN  LifterError = 9
+	LIFTER_ERROR_LEAVE_LAYER_TIMEOUT  LifterError = 10
+	LIFTER_ERROR_LIGHT_BLOCKED        LifterError = 11 // "10i", 11 to 19 for layer 1 to 9
+	LIFTER_ERROR_LIGHT_NO_SIGNAL      LifterError = 21 // "20i", 21 to 29 for layer 1 to 9
+	LIFTER_ERROR_ORIGIN_NOT_RELEASED  LifterError = 30
+	LIFTER_ERROR_PUSH_TIMEOUT         LifterError = 31
+	LIFTER_ERROR_PUSH_OVERCURRENT     LifterError = 32
+	LIFTER_ERROR_PUSH_NO_CURRENT      LifterError = 33
+	LIFTER_ERROR_NO_GOODS_AT_PICKUP   LifterError = 34
+	LIFTER_ERROR_GOODS_IN_HOPPER      LifterError = 35
+	LIFTER_ERROR_GOODS_STUCK          LifterError = 36
+	LIFTER_ERROR_LIFT_MOTOR_OPEN      LifterError = 37
+	LIFTER_ERROR_DRIVE_BOARD          LifterError = 40
+	LIFTER_ERROR_FLASH_ERASE          LifterError = 41
+	LIFTER_ERROR_FLASH_WRITE          LifterError = 42
+	LIFTER_ERROR_WRONG_COMMAND        LifterError = 43
+	LIFTER_ERROR_CHECKSUM             LifterError = 44
+	LIFTER_ERROR_DOOR_NOT_CLOSED      LifterError = 45
+	LIFTER_ERROR_SECOND_BELT_PURCHASE LifterError = 46
+	LIFTER_ERROR_LAYER_TIMEOUT        LifterError = 47 // 47, 50, 53, 56, 59 for layer 1 to 5
+	LIFTER_ERROR_LAYER_OVERCURRENT    LifterError = 48 // 48, 51, 54, 57, 60 for layer 1 to 5
+	LIFTER_ERROR_LAYER_DISCONNECTED   LifterError = 49 // 49, 52, 55, 58, 61 for layer 1 to 5
+	LIFTER_ERROR_INVALID_MOTOR        LifterError = 64
+	LIFTER_ERROR_ROTATION_TIMEOUT     LifterError = 80
+	LIFTER_ERROR_NO_RESPONSE          LifterError = 127
+)
+
+type (
+	// LifterError is the error byte of the lifter status.
+	LifterError byte
+
+	lifterErrorInfo struct {
+		message    string
+		messageZh  string
+		severity   string
+		retryable  bool
+		technician bool
+	}
+)
+
+var (
+	lifterErrors = map[LifterError]lifterErrorInfo{
+		LIFTER_ERROR_LOCK_SWITCH_ON_LOCK:  {"Lock switch not detected when locking the door", "锁门时锁开关没检测到位", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_DOOR_SWITCH_ON_LOCK:  {"Door switch not detected when locking the door", "锁门时门开关没检测到位", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_LIFT_OVERCURRENT:     {"Lifting motor current is too large", "升降电机电流过大", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_STEPS_OVER_LIMIT:     {"Bottom not reached after the step limit", "超过极限步数还没到底", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_TOO_FEW_LAYERS:       {"Fewer layers detected than the layer to ship from", "检测到的最大层数比现在要出货的层数还少", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_HOME_TIMEOUT:         {"Timeout going back to origin", "回原点运行超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_RUN_TIMEOUT:          {"Timeout during normal operation", "正常运行时超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_FALL_TIMEOUT:         {"Timeout while going down", "下降正常运行时超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_LOCK_SWITCH_ON_OPEN:  {"Lock switch not detected when opening the door", "开门时锁开关没检测到位", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_LEAVE_LAYER_TIMEOUT:  {"Timeout waiting for the light detection when leaving the layer", "等待离开层检测光检超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_LIGHT_BLOCKED:        {"Lifter light detection is blocked", "升降机光检被挡住", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_LIGHT_NO_SIGNAL:      {"Lifter light detection receives without sending", "升降机光检不发送也有接收", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_ORIGIN_NOT_RELEASED:  {"Origin switch still not released after moving up", "往上移动了一段距离，但原点开关仍然没放开", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_PUSH_TIMEOUT:         {"Push plate running timeout", "推板运行超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_PUSH_OVERCURRENT:     {"Push plate current is too large", "推板电流过大", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_PUSH_NO_CURRENT:      {"Push plate never has current", "推板从来没有电流", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_NO_GOODS_AT_PICKUP:   {"No goods at the pickup port", "取货口没有货物", SEVERITY_ERROR, false, false},
+		LIFTER_ERROR_GOODS_IN_HOPPER:      {"Goods in the hopper before sale", "售货前货斗里面有货", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_GOODS_STUCK:          {"Goods stuck at the slot exit", "货在货道口被卡住", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_LIFT_MOTOR_OPEN:      {"Lifting motor open circuit", "升降电机开路", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_DRIVE_BOARD:          {"Slot drive board failure", "货道驱动板故障", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_FLASH_ERASE:          {"FLASH erase error", "FLASH檫除错误", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_FLASH_WRITE:          {"FLASH write error", "FLASH写错误", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_WRONG_COMMAND:        {"Wrong command", "错误命令", SEVERITY_ERROR, false, false},
+		LIFTER_ERROR_CHECKSUM:             {"Checksum error", "校验错误", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_DOOR_NOT_CLOSED:      {"The door is not closed", "柜门没关", SEVERITY_WARNING, true, false},
+		LIFTER_ERROR_SECOND_BELT_PURCHASE: {"Second purchase from a belt slot", "第二次购买到履带货道", SEVERITY_ERROR, false, false},
+		LIFTER_ERROR_LAYER_TIMEOUT:        {"Layer %d timeout", "%d层超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_LAYER_OVERCURRENT:    {"Layer %d overcurrent", "%d层过流", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_LAYER_DISCONNECTED:   {"Layer %d disconnected (no current on both directions)", "%d层断线（正反都没有电流）", SEVERITY_CRITICAL, false, true},
+		LIFTER_ERROR_INVALID_MOTOR:        {"Invalid motor", "无效电机", SEVERITY_ERROR, false, true},
+		LIFTER_ERROR_ROTATION_TIMEOUT:     {"Rotation timeout", "转动超时", SEVERITY_ERROR, true, false},
+		LIFTER_ERROR_NO_RESPONSE:          {"The driver board does not respond to commands", "驱动板不回复命令", SEVERITY_ERROR, true, false},
+	}
+)
+
+// Kind returns the error with the layer removed, e.g. 50 (layer 2 timeout)
+// becomes LIFTER_ERROR_LAYER_TIMEOUT and 12 becomes LIFTER_ERROR_LIGHT_BLOCKED.
+func (e LifterError) Kind() LifterError {
+	switch {
+	case e > 10 && e < 20:
+		return LIFTER_ERROR_LIGHT_BLOCKED
+	case e > 20 && e < 30:
+		return LIFTER_ERROR_LIGHT_NO_SIGNAL
+	case e.isMotorLayer():
+		return LIFTER_ERROR_LAYER_TIMEOUT + (e-47)%3
+	}
+	return e
+}
+
+// Layer returns the layer the error is about, or 0 if it is not about a
+// layer.
+func (e LifterError) Layer() int {
+	switch {
+	case e > 10 && e < 20:
+		return int(e) - 10
+	case e > 20 && e < 30:
+		return int(e) - 20
+	case e.isMotorLayer():
+		return int(e-47)/3 + 1
+	}
+	return 0
+}
+
+// Code returns the error code as in README.md, e.g. "07", "10i".
+func (e LifterError) Code() string {
+	switch e.Kind() {
+	case LIFTER_ERROR_LIGHT_BLOCKED:
+		return "10i"
+	case LIFTER_ERROR_LIGHT_NO_SIGNAL:
+		return "20i"
+	}
+	return fmt.Sprintf("%02d", byte(e))
+}
+
+func (e LifterError) Error() string {
+	return e.Message()
+}
+
+func (e LifterError) Message() string {
+	if e == LIFTER_ERROR_NONE {
+		return ""
+	}
+	info, ok := lifterErrors[e.Kind()]
+	if !ok {
+		return fmt.Sprintf("Unknown error %d", byte(e))
+	}
+	if e.isMotorLayer() {
+		return fmt.Sprintf(info.message, e.Layer())
+	}
+	return info.message
+}
+
+func (e LifterError) ChineseMessage() string {
+	if e == LIFTER_ERROR_NONE {
+		return ""
+	}
+	info, ok := lifterErrors[e.Kind()]
+	if !ok {
+		return fmt.Sprintf("未知错误%d", byte(e))
+	}
+	if e.isMotorLayer() {
+		return fmt.Sprintf(info.messageZh, e.Layer())
+	}
+	return info.messageZh
+}
+
+// Severity returns one of SEVERITY_WARNING, SEVERITY_ERROR or
+// SEVERITY_CRITICAL, or empty string if there is no error.
+func (e LifterError) Severity() string {
+	if e == LIFTER_ERROR_NONE {
+		return ""
+	}
+	if info, ok := lifterErrors[e.Kind()]; ok {
+		return info.severity
+	}
+	return SEVERITY_ERROR
+}
+
+// Retryable reports whether the same operation may succeed if tried again,
+// after clearing errors.
+func (e LifterError) Retryable() bool {
+	return lifterErrors[e.Kind()].retryable
+}
+
+// NeedsTechnician reports whether the error can only be fixed on site.
+func (e LifterError) NeedsTechnician() bool {
+	return lifterErrors[e.Kind()].technician
+}
+
+// isMotorLayer reports whether the error is one of the per-layer motor
+// errors, whose messages contain the layer number.
+func (e LifterError) isMotorLayer() bool {
+	return e >= 47 && e <= 61
+}
+
+func (e LifterError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Code            string `json:"code"`
+		Kind            string `json:"kind"`
+		Layer           int    `json:"layer"`
+		Message         string `json:"message"`
+		MessageZh       string `json:"message_zh"`
+		Severity        string `json:"severity"`
+		Retryable       bool   `json:"retryable"`
+		NeedsTechnician bool   `json:"needs_technician"`
+	}{
+		Code:            e.Code(),
+		Kind:            e.Kind().Code(),
+		Layer:           e.Layer(),
+		Message:         e.Message(),
+		MessageZh:       e.ChineseMessage(),
+		Severity:        e.Severity(),
+		Retryable:       e.Retryable(),
+		NeedsTechnician: e.NeedsTechnician(),
+	})
+}
@@ -0,0 +1,89 @@
+package tcn_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+func TestLifterErrorLayers(t *testing.T) {
+	tests := []struct {
+		e       tcn.LifterError
+		kind    tcn.LifterError
+		layer   int
+		code    string
+		message string
+	}{
+		{7, tcn.LIFTER_ERROR_RUN_TIMEOUT, 0, "07", "Timeout during normal operation"},
+		{13, tcn.LIFTER_ERROR_LIGHT_BLOCKED, 3, "10i", "Lifter light detection is blocked"},
+		{29, tcn.LIFTER_ERROR_LIGHT_NO_SIGNAL, 9, "20i", "Lifter light detection receives without sending"},
+		{47, tcn.LIFTER_ERROR_LAYER_TIMEOUT, 1, "47", "Layer 1 timeout"},
+		{51, tcn.LIFTER_ERROR_LAYER_OVERCURRENT, 2, "51", "Layer 2 overcurrent"},
+		{61, tcn.LIFTER_ERROR_LAYER_DISCONNECTED, 5, "61", "Layer 5 disconnected (no current on both directions)"},
+		{99, 99, 0, "99", "Unknown error 99"},
+	}
+	for _, test := range tests {
+		if kind := test.e.Kind(); kind != test.kind {
+			t.Errorf("%d: kind = %d, want %d", test.e, kind, test.kind)
+		}
+		if layer := test.e.Layer(); layer != test.layer {
+			t.Errorf("%d: layer = %d, want %d", test.e, layer, test.layer)
+		}
+		if code := test.e.Code(); code != test.code {
+			t.Errorf("%d: code = %s, want %s", test.e, code, test.code)
+		}
+		if message := test.e.Error(); message != test.message {
+			t.Errorf("%d: message = %q, want %q", test.e, message, test.message)
+		}
+	}
+	if message := tcn.LifterError(50).ChineseMessage(); message != "2层超时" {
+		t.Errorf("chinese message = %q", message)
+	}
+}
+
+func TestLifterErrorClassification(t *testing.T) {
+	if e := tcn.LIFTER_ERROR_NONE; e.Severity() != "" || e.Retryable() || e.NeedsTechnician() || e.Message() != "" {
+		t.Errorf("none is classified as an error")
+	}
+	if e := tcn.LifterError(54); e.Severity() != tcn.SEVERITY_CRITICAL || e.Retryable() || !e.NeedsTechnician() {
+		t.Errorf("layer 3 overcurrent: severity = %s, retryable = %v, technician = %v", e.Severity(), e.Retryable(), e.NeedsTechnician())
+	}
+	if e := tcn.LIFTER_ERROR_DOOR_NOT_CLOSED; e.Severity() != tcn.SEVERITY_WARNING || !e.Retryable() || e.NeedsTechnician() {
+		t.Errorf("door not closed: severity = %s, retryable = %v, technician = %v", e.Severity(), e.Retryable(), e.NeedsTechnician())
+	}
+	if e := tcn.LifterError(99); e.Severity() != tcn.SEVERITY_ERROR {
+		t.Errorf("unknown: severity = %s", e.Severity())
+	}
+}
+
+func TestLifterErrorJSON(t *testing.T) {
+	b, err := json.Marshal(tcn.LifterError(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply map[string]interface{}
+	if err := json.Unmarshal(b, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply["code"] != "10i" || reply["kind"] != "10i" || reply["layer"] != float64(6) || reply["severity"] != tcn.SEVERITY_WARNING || reply["retryable"] != true {
+		t.Errorf("json = %s", b)
+	}
+}
+
+func TestLifterStatusError(t *testing.T) {
+	m, board := newMachine()
+	board.SetLifterError(53)
+	status, err := m.LifterStatus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.OK || status.Error != 53 || status.ErrorCode != "53" || status.Error.Layer() != 3 {
+		t.Errorf("status = %+v", status)
+	}
+	board.SetLifterError(tcn.LIFTER_ERROR_NONE)
+	if status, err := m.LifterStatus(context.Background()); err != nil || !status.OK || status.Error != tcn.LIFTER_ERROR_NONE {
+		t.Errorf("status = %+v, err = %v", status, err)
+	}
+}
@@ -0,0 +1,274 @@
+package tcn
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeout      = errors.New("timeout")
+	ErrProcessing   = errors.New("already processing")
+	ErrNoContent    = errors.New("no content")
+	ErrNoSuchClient = errors.New("no such client")
+)
+
+type (
+	Client interface {
+		GetChannels() *sync.Map
+		Write([]byte) (int, error)
+	}
+
+	// Machine sends commands to one TCN board. Every method waits for the
+	// reply until ctx is done; if ctx has no deadline, the default timeout of
+	// the command applies.
+	Machine struct {
+		// name of the client in logs
+		ID     string
+		Client Client
+		// optional, see Queue
+		Queue *Queue
+		// optional, see RetryPolicy
+		Retry *RetryPolicy
+		// optional, see SlotHealth
+		SlotHealth *SlotHealth
+		// optional, called after every try of Check, Status, Rotate,
+		// LifterStatus and the ship command of LifterShip
+		OnAttempt func(Attempt)
+		HideLogs  bool
+	}
+
+	Status struct {
+		Time              time.Time
+		ActualTemperature int
+	}
+)
+
+func (m *Machine) Check(ctx context.Context) error {
+	return m.retry(ctx, RETRY_READ, "check", func(ctx context.Context) error {
+		_, err := m.write(ctx, BasicBytes(0xDF, 0x55), KEY_DEFAULT, 1000)
+		return err
+	})
+}
+
+func (m *Machine) MergeCell(ctx context.Context, number int) error {
+	_, err := m.write(ctx, BasicBytes(0xCA, byte(number)), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) UnmergeCell(ctx context.Context, number int) error {
+	_, err := m.write(ctx, BasicBytes(0xC9, byte(number)), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) SetCellAsBelt(ctx context.Context, number int) error {
+	_, err := m.write(ctx, BasicBytes(0x68, byte(number)), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) SetCellAsSpring(ctx context.Context, number int) error {
+	_, err := m.write(ctx, BasicBytes(0x74, byte(number)), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) SetAllCellsAsBelt(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0x76, 0x55), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) SetAllCellsAsSpring(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0x75, 0x55), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) Status(ctx context.Context) (Status, error) {
+	var b []byte
+	err := m.retry(ctx, RETRY_READ, "status", func(ctx context.Context) (err error) {
+		b, err = m.write(ctx, BasicBytes(0xDC, 0x55), KEY_DEFAULT, 1000)
+		return
+	})
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Time:              time.Now(),
+		ActualTemperature: int(b[2]),
+	}, nil
+}
+
+// Rotate rotates the motor of the slot and reports whether the board
+// replied with success. It waits 10 seconds if ctx has no deadline. It is
+// only retried if it fails before it is written, see RetryPolicy.
+func (m *Machine) Rotate(ctx context.Context, number int) (bool, error) {
+	if m.SlotHealth != nil {
+		release, err := m.SlotHealth.reserve(ctx, m.ID, number)
+		if err != nil {
+			return false, err
+		}
+		defer release()
+	}
+	start := time.Now()
+	var b []byte
+	err := m.retry(ctx, RETRY_MOTOR, "rotate", func(ctx context.Context) (err error) {
+		b, err = m.write(ctx, BasicBytes(byte(number), 0xAA), KEY_DEFAULT, 0)
+		return
+	})
+	m.recordSlot(number, start, err, IsRotateSuccess(b), fmt.Sprintf("%X", b))
+	if err != nil {
+		return false, err
+	}
+	return IsRotateSuccess(b), nil
+}
+
+func (m *Machine) RotateAll(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0x65, 0x55), KEY_DEFAULT, 3*60*1000)
+	return err
+}
+
+func (m *Machine) TurnOnHeater(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0xD4, 0x01), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) TurnOffHeater(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0xD4, 0x00), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) TurnOnLights(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0xDD, 0xAA), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) TurnOffLights(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0xDD, 0x55), KEY_DEFAULT, 1000)
+	return err
+}
+
+func (m *Machine) TurnOnRefrigerator(ctx context.Context, temperature int) error {
+	_, err := m.write(ctx, BasicBytes(0xCC, 0x01), KEY_DEFAULT, 1000)
+	if err == nil {
+		_, err = m.write(ctx, BasicBytes(0xCD, 0x01), KEY_DEFAULT, 1000)
+	}
+	if err == nil {
+		_, err = m.write(ctx, BasicBytes(0xCE, byte(temperature)), KEY_DEFAULT, 1000)
+	}
+	return err
+}
+
+func (m *Machine) TurnOffRefrigerator(ctx context.Context) error {
+	_, err := m.write(ctx, BasicBytes(0xCC, 0x00), KEY_DEFAULT, 1000)
+	return err
+}
+
+// SendRaw writes input as is and returns the reply dispatched to key, e.g.
+// KEY_DEFAULT for basic commands or FunctionKey for lifter commands. It is
+// meant for trying undocumented commands. It waits 10 seconds if ctx has no
+// deadline.
+func (m *Machine) SendRaw(ctx context.Context, input []byte, key string) ([]byte, error) {
+	return m.write(ctx, input, key, 0)
+}
+
+// recordSlot adds the result of a command to the slot, unless the command
+// failed before it was sent.
+func (m *Machine) recordSlot(number int, start time.Time, err error, ok bool, errorCode string) {
+	if m.SlotHealth == nil {
+		return
+	}
+	duration := int(time.Since(start) / time.Millisecond)
+	if err != nil {
+		if err != ErrTimeout {
+			return
+		}
+		duration = -1
+		errorCode = err.Error()
+	}
+	m.SlotHealth.record(m.ID, number, duration, err == nil && ok, errorCode)
+}
+
+// IsRotateSuccess reports whether b is the reply of a successful rotation.
+func IsRotateSuccess(b []byte) bool {
+	return bytes.Equal(b, []byte{0x00, 0x5D, 0x00, 0xAA, 0x07})
+}
+
+// BasicBytes returns the 0x00 0xFF command frame.
+func BasicBytes(primary byte, secondary byte) []byte {
+	return []byte{0x00, 0xFF, primary, primary ^ 0xFF, secondary, secondary ^ 0xFF}
+}
+
+// write sends input and waits for the reply on channelKey. timeout is in
+// milliseconds, 0 means 10 seconds unless ctx has a deadline.
+func (m *Machine) write(ctx context.Context, input []byte, channelKey string, timeout int) (output []byte, err error) {
+	if len(input) == 0 {
+		err = ErrNoContent
+		return
+	}
+	if m.Client == nil {
+		err = ErrNoSuchClient
+		return
+	}
+	if m.Queue != nil {
+		var release func()
+		release, err = m.Queue.Acquire(ctx, m.ID, channelKey)
+		if err != nil {
+			return
+		}
+		defer release()
+	}
+	channels := m.Client.GetChannels()
+	// buffered so the reply is kept if it arrives before the select below
+	channel, hasChannel := channels.LoadOrStore(channelKey, make(chan []byte, 1))
+	if hasChannel {
+		err = ErrProcessing
+		return
+	} else {
+		defer channels.Delete(channelKey)
+	}
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+	var n int
+	n, err = m.Client.Write(input)
+	if m.HideLogs == false {
+		if m.ID == "" {
+			log.Printf("%d bytes written: % X", n, input)
+		} else {
+			log.Printf("%s %d bytes written: % X", m.ID, n, input)
+		}
+	}
+	if err == nil {
+		select {
+		case output = <-channel.(chan []byte):
+			return
+		case <-ctx.Done():
+			err = contextError(ctx)
+			return
+		}
+	} else {
+		log.Println("error writting", input, err)
+	}
+	return
+}
+
+func withTimeout(ctx context.Context, t int) (context.Context, context.CancelFunc) {
+	if t == 0 {
+		if _, ok := ctx.Deadline(); ok {
+			return context.WithCancel(ctx)
+		}
+		t = 10000
+	} else if t < 100 {
+		t = 100
+	}
+	return context.WithTimeout(ctx, time.Duration(t)*time.Millisecond)
+}
+
+// contextError returns ErrTimeout if the deadline of ctx is exceeded.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,80 @@
+package tcn_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func newMachine() (*tcn.Machine, *sim.Board) {
+	board := sim.NewBoard()
+	board.RotateDuration = 50 * time.Millisecond
+	board.ShipDuration = 100 * time.Millisecond
+	return &tcn.Machine{ID: "a", Client: board, HideLogs: true}, board
+}
+
+func TestMachineRotate(t *testing.T) {
+	m, board := newMachine()
+	ctx := context.Background()
+	if ok, err := m.Rotate(ctx, 1); err != nil || !ok {
+		t.Errorf("ok = %v, err = %v, want true", ok, err)
+	}
+	board.Jam(2, true)
+	if ok, err := m.Rotate(ctx, 2); err != nil || ok {
+		t.Errorf("jammed: ok = %v, err = %v, want false", ok, err)
+	}
+	if stock := board.Stock(1); stock != board.Capacity-1 {
+		t.Errorf("stock = %d, want %d", stock, board.Capacity-1)
+	}
+}
+
+func TestMachineContext(t *testing.T) {
+	m, board := newMachine()
+	board.RotateDuration = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() {
+		_, err := m.Rotate(ctx, 1)
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if err := m.Check(context.Background()); err != tcn.ErrProcessing {
+		t.Errorf("err = %v, want ErrProcessing", err)
+	}
+	if err := <-done; err != tcn.ErrTimeout {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if _, err := m.Status(ctx); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestMachineStatus(t *testing.T) {
+	m, board := newMachine()
+	board.SetTemperature(7)
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ActualTemperature != 7 {
+		t.Errorf("temperature = %d, want 7", status.ActualTemperature)
+	}
+}
+
+func TestMachineLifterQueryParameters(t *testing.T) {
+	m, board := newMachine()
+	board.SetParameter(0x10, 300)
+	data, err := m.LifterQueryParameters(context.Background(), 0x10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.Value() != 300 {
+		t.Errorf("value = %d, want 300", data.Value())
+	}
+}
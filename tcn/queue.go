@@ -0,0 +1,125 @@
+package tcn
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueFull    = errors.New("queue is full")
+	ErrQueueTimeout = errors.New("timed out waiting in queue")
+)
+
+type (
+	// Queue lets commands to the same client wait for their turn instead of
+	// failing when another command is using the serial bus. Commands with
+	// higher priority go first, commands with the same priority are run in
+	// the order they arrive.
+	Queue struct {
+		// milliseconds a command may wait for its turn, 0 means no limit
+		MaxWait int
+		// number of commands that can wait per client, 0 means no limit
+		MaxLength int
+		// priority of each channel key, e.g. tcn.KEY_STATUS, defaults to 0
+		Priorities map[string]int
+
+		mu      sync.Mutex
+		clients map[string]*clientQueue
+	}
+
+	clientQueue struct {
+		busy    bool
+		waiting []*queued
+	}
+
+	queued struct {
+		priority int
+		ready    chan struct{}
+	}
+)
+
+// Acquire waits until the client is free or ctx is done and returns a
+// function that must be called when the command is done.
+func (q *Queue) Acquire(ctx context.Context, clientId, key string) (release func(), err error) {
+	q.mu.Lock()
+	if q.clients == nil {
+		q.clients = map[string]*clientQueue{}
+	}
+	cq, ok := q.clients[clientId]
+	if !ok {
+		cq = &clientQueue{}
+		q.clients[clientId] = cq
+	}
+	release = func() {
+		q.release(clientId, cq)
+	}
+	if !cq.busy {
+		cq.busy = true
+		q.mu.Unlock()
+		return
+	}
+	if q.MaxLength > 0 && len(cq.waiting) >= q.MaxLength {
+		q.mu.Unlock()
+		return nil, ErrQueueFull
+	}
+	item := &queued{
+		priority: q.Priorities[key],
+		ready:    make(chan struct{}),
+	}
+	cq.waiting = append(cq.waiting, item)
+	sort.SliceStable(cq.waiting, func(i, j int) bool {
+		return cq.waiting[i].priority > cq.waiting[j].priority
+	})
+	q.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if q.MaxWait > 0 {
+		timer := time.NewTimer(time.Duration(q.MaxWait) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-item.ready:
+		return
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = contextError(ctx)
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range cq.waiting {
+		if w == item {
+			cq.waiting = append(cq.waiting[:i], cq.waiting[i+1:]...)
+			return nil, err
+		}
+	}
+	// our turn came right after the timeout
+	return release, nil
+}
+
+// Len returns the number of commands waiting for the client.
+func (q *Queue) Len(clientId string) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if cq, ok := q.clients[clientId]; ok {
+		return len(cq.waiting)
+	}
+	return 0
+}
+
+func (q *Queue) release(clientId string, cq *clientQueue) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(cq.waiting) == 0 {
+		cq.busy = false
+		delete(q.clients, clientId)
+		return
+	}
+	next := cq.waiting[0]
+	cq.waiting = cq.waiting[1:]
+	close(next.ready)
+}
@@ -0,0 +1,106 @@
+package tcn_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func TestQueuePriority(t *testing.T) {
+	q := &tcn.Queue{
+		Priorities: map[string]int{tcn.KEY_STATUS: 1},
+	}
+	ctx := context.Background()
+	release, err := q.Acquire(ctx, "a", tcn.KEY_DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := make(chan string, 2)
+	var wg sync.WaitGroup
+	for i, key := range []string{tcn.KEY_DEFAULT, tcn.KEY_STATUS} {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			release, err := q.Acquire(ctx, "a", key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- key
+			release()
+		}(key)
+		waitFor(t, func() bool { return q.Len("a") == i+1 })
+	}
+	release()
+	wg.Wait()
+	if first := <-order; first != tcn.KEY_STATUS {
+		t.Errorf("first = %s, want %s", first, tcn.KEY_STATUS)
+	}
+}
+
+func TestQueueLimits(t *testing.T) {
+	q := &tcn.Queue{
+		MaxWait:   50,
+		MaxLength: 1,
+	}
+	ctx := context.Background()
+	release, err := q.Acquire(ctx, "a", tcn.KEY_DEFAULT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	done := make(chan error)
+	go func() {
+		_, err := q.Acquire(ctx, "a", tcn.KEY_DEFAULT)
+		done <- err
+	}()
+	waitFor(t, func() bool { return q.Len("a") == 1 })
+	if _, err := q.Acquire(ctx, "a", tcn.KEY_DEFAULT); err != tcn.ErrQueueFull {
+		t.Errorf("err = %v, want ErrQueueFull", err)
+	}
+	if err := <-done; err != tcn.ErrQueueTimeout {
+		t.Errorf("err = %v, want ErrQueueTimeout", err)
+	}
+	if _, err := q.Acquire(ctx, "b", tcn.KEY_DEFAULT); err != nil {
+		t.Errorf("other client: err = %v, want nil", err)
+	}
+}
+
+func TestQueueMachine(t *testing.T) {
+	board := sim.NewBoard()
+	board.RotateDuration = 50 * time.Millisecond
+	q := &tcn.Queue{}
+	var wg sync.WaitGroup
+	for slot := 1; slot <= 3; slot++ {
+		wg.Add(1)
+		go func(slot int) {
+			defer wg.Done()
+			m := &tcn.Machine{ID: "a", Client: board, Queue: q, HideLogs: true}
+			ok, err := m.Rotate(context.Background(), slot)
+			if err != nil || !ok {
+				t.Errorf("slot %d: ok = %v, err = %v", slot, ok, err)
+			}
+		}(slot)
+	}
+	wg.Wait()
+	for slot := 1; slot <= 3; slot++ {
+		if stock := board.Stock(slot); stock != board.Capacity-1 {
+			t.Errorf("slot %d: stock = %d, want %d", slot, stock, board.Capacity-1)
+		}
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
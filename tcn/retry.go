@@ -0,0 +1,48 @@
+package tcn
+
+import (
+	"context"
+
+	"github.com/caiguanhao/vending-processors/retry"
+)
+
+const (
+	// commands that only read, e.g. Check, Status and LifterStatus
+	RETRY_READ = retry.READ
+	// commands that drive a motor, e.g. Rotate and LifterShip
+	RETRY_MOTOR = retry.MOTOR
+)
+
+type (
+	Retry = retry.Retry
+
+	// RetryPolicy retries commands that fail with ErrTimeout or
+	// LIFTER_ERROR_NO_RESPONSE, which are often transient, or with an error
+	// returned before they are written, e.g. ErrQueueTimeout. Read commands
+	// are retried after either. Motor commands are only retried after an
+	// error returned before they are written, since nothing tells whether
+	// the board has accepted one that timed out.
+	RetryPolicy = retry.Policy
+
+	Attempt = retry.Attempt
+)
+
+// retry calls command as allowed by the RetryPolicy of m.
+func (m *Machine) retry(ctx context.Context, class, name string, command func(context.Context) error) error {
+	return m.Retry.Do(ctx, retry.Command{
+		Class:     class,
+		Name:      name,
+		Run:       command,
+		Transient: isTransient,
+		NotSent:   isNotSent,
+	}, m.OnAttempt)
+}
+
+func isTransient(err error) bool {
+	return err == ErrTimeout || err == LIFTER_ERROR_NO_RESPONSE
+}
+
+// isNotSent reports whether err is returned before a command is written.
+func isNotSent(err error) bool {
+	return err == ErrProcessing || err == ErrQueueFull || err == ErrQueueTimeout
+}
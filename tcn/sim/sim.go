@@ -0,0 +1,351 @@
+// Package sim simulates a TCN board with a lifter, so that tcn/jsonrpc can be
+// used without real hardware.
+package sim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/fault"
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+type (
+	// Board implements the Client interface of tcn/jsonrpc. Replies are
+	// checksummed like the real board's and fed back through tcn.Process.
+	// Use NewBoard to get a board with default settings.
+	Board struct {
+		// number of slots, defaults to 60
+		Slots int
+		// products each slot starts with, defaults to 10
+		Capacity int
+		// time before each reply, defaults to 20 milliseconds
+		Delay time.Duration
+		// time a slot motor rotates, defaults to 500 milliseconds
+		RotateDuration time.Duration
+		// time the lifter is busy shipping, defaults to 3 seconds
+		ShipDuration time.Duration
+		// optional, applies the inbound rules to the replies
+		Faults *fault.Injector
+
+		channels sync.Map
+
+		replyMu  sync.Mutex
+		received []byte
+
+		mu          sync.Mutex
+		input       []byte
+		stock       map[int]int
+		jammed      map[int]bool
+		temperature int
+		target      int
+		heater      bool
+		lights      bool
+		cooling     bool
+
+		lifterBusy   bool
+		lifterError  tcn.LifterError
+		productReady bool
+		parameters   map[int]int
+	}
+
+	State struct {
+		Temperature       int
+		TargetTemperature int
+		Heater            bool
+		Lights            bool
+		Cooling           bool
+		LifterBusy        bool
+		LifterError       tcn.LifterError
+		ProductReady      bool
+	}
+)
+
+func NewBoard() *Board {
+	return &Board{
+		Slots:          60,
+		Capacity:       10,
+		Delay:          20 * time.Millisecond,
+		RotateDuration: 500 * time.Millisecond,
+		ShipDuration:   3 * time.Second,
+		temperature:    25,
+	}
+}
+
+func (b *Board) GetChannels() *sync.Map {
+	return &b.channels
+}
+
+// Write receives commands from the host, replies are sent after Delay.
+func (b *Board) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.input = append(b.input, p...)
+	for len(b.input) > 0 {
+		if b.input[0] == 0x00 {
+			if len(b.input) < 6 {
+				break
+			}
+			frame := b.input[:6]
+			if frame[1] == 0xFF && frame[2]^frame[3] == 0xFF && frame[4]^frame[5] == 0xFF {
+				b.basic(frame[2], frame[4])
+				b.input = b.input[6:]
+				continue
+			}
+		} else if b.input[0] == 0x02 {
+			if len(b.input) < 2 {
+				break
+			}
+			size := 2 + int(b.input[1]) + 2
+			if len(b.input) < size {
+				break
+			}
+			frame := b.input[:size]
+			if frame[size-2] == 0x03 && xor(frame[:size-1]) == frame[size-1] && size >= 6 {
+				b.lifter(frame[2], append([]byte{}, frame[3:size-3]...))
+				b.input = b.input[size:]
+				continue
+			}
+		}
+		b.input = b.input[1:]
+	}
+	return len(p), nil
+}
+
+// SetStock sets the number of products in the slot.
+func (b *Board) SetStock(slot, n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.stock[slot] = n
+}
+
+func (b *Board) Stock(slot int) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	return b.stock[slot]
+}
+
+// Jam makes the slot fail to rotate or ship.
+func (b *Board) Jam(slot int, jammed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.jammed[slot] = jammed
+}
+
+func (b *Board) SetTemperature(temperature int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.temperature = temperature
+}
+
+// SetLifterError makes the lifter report the error until it is cleared.
+func (b *Board) SetLifterError(e tcn.LifterError) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lifterError = e
+}
+
+// SetParameter sets the value the lifter reports for the parameter index.
+func (b *Board) SetParameter(index, value int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.init()
+	b.parameters[index] = value
+}
+
+// Collect takes the product out of the lifter tray.
+func (b *Board) Collect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.productReady = false
+}
+
+func (b *Board) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return State{
+		Temperature:       b.temperature,
+		TargetTemperature: b.target,
+		Heater:            b.heater,
+		Lights:            b.lights,
+		Cooling:           b.cooling,
+		LifterBusy:        b.lifterBusy,
+		LifterError:       b.lifterError,
+		ProductReady:      b.productReady,
+	}
+}
+
+func (b *Board) init() {
+	if b.stock != nil {
+		return
+	}
+	b.stock = map[int]int{}
+	b.jammed = map[int]bool{}
+	b.parameters = map[int]int{}
+	capacity := b.Capacity
+	if capacity == 0 {
+		capacity = 10
+	}
+	for i := 1; i <= b.slots(); i++ {
+		b.stock[i] = capacity
+	}
+}
+
+func (b *Board) slots() int {
+	if b.Slots == 0 {
+		return 60
+	}
+	return b.Slots
+}
+
+// basic handles the 0x00 0xFF commands, the lock is held.
+func (b *Board) basic(primary, secondary byte) {
+	b.init()
+	switch {
+	case secondary == 0xAA && primary >= 1 && int(primary) <= b.slots():
+		slot := int(primary)
+		ok := !b.jammed[slot] && b.stock[slot] > 0
+		if ok {
+			b.stock[slot]--
+		}
+		rotate := b.RotateDuration
+		if rotate == 0 {
+			rotate = 500 * time.Millisecond
+		}
+		b.after(rotate, func() {
+			if ok {
+				b.reply(basicReply(0x00, 0xAA))
+			} else {
+				b.reply(basicReply(0x00, 0x55))
+			}
+		})
+		return
+	case primary == 0xDC:
+		b.replyAfter(basicReply(byte(b.temperature), 0x00))
+		return
+	case primary == 0xD4:
+		b.heater = secondary == 0x01
+	case primary == 0xDD:
+		b.lights = secondary == 0xAA
+	case primary == 0xCC:
+		b.cooling = secondary == 0x01
+	case primary == 0xCE:
+		b.target = int(secondary)
+	}
+	b.replyAfter(basicReply(0x00, 0x00))
+}
+
+// lifter handles the 0x02 ... 0x03 commands, the lock is held.
+func (b *Board) lifter(function byte, data []byte) {
+	b.init()
+	switch function {
+	case tcn.FUNC_LIFTER_SHIP:
+		b.ship(data)
+	case tcn.FUNC_LIFTER_CLEAR_ERRORS:
+		b.lifterError = tcn.LIFTER_ERROR_NONE
+	case tcn.FUNC_LIFTER_OPERATE_TRAY:
+		if len(data) > 1 && data[1] == 0x01 {
+			b.productReady = false
+		}
+	case tcn.FUNC_LIFTER_GET_PARAMETERS:
+		var index byte
+		if len(data) > 1 {
+			index = data[1]
+		}
+		value := b.parameters[int(index)]
+		b.replyAfter(tcn.LifterBytes(function, 0x00, index, byte(value>>8), byte(value)))
+		return
+	case tcn.FUNC_LIFTER_CHECK_EXISTENCE:
+		var exists byte
+		if b.productReady {
+			exists = 0x01
+		}
+		b.replyAfter(tcn.LifterBytes(function, 0x00, exists))
+		return
+	case tcn.FUNC_LIFTER_GET_DRIVER, tcn.FUNC_LIFTER_DETECT_LIGHT, tcn.FUNC_LIFTER_DETECT_INPUT:
+		b.replyAfter(tcn.LifterBytes(function, 0x00, 0x00))
+		return
+	}
+	b.replyAfter(b.lifterStatus(function))
+}
+
+func (b *Board) ship(data []byte) {
+	if b.lifterBusy || b.lifterError != tcn.LIFTER_ERROR_NONE || len(data) < 2 {
+		return
+	}
+	slot := int(data[1])
+	b.lifterBusy = true
+	ship := b.ShipDuration
+	if ship == 0 {
+		ship = 3 * time.Second
+	}
+	b.after(ship, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.lifterBusy = false
+		switch {
+		case b.jammed[slot]:
+			b.lifterError = tcn.LIFTER_ERROR_GOODS_STUCK
+		case b.stock[slot] < 1:
+			b.lifterError = tcn.LIFTER_ERROR_NO_GOODS_AT_PICKUP
+		default:
+			b.stock[slot]--
+			b.productReady = true
+		}
+	})
+}
+
+func (b *Board) lifterStatus(function byte) []byte {
+	var status byte
+	if b.lifterBusy {
+		status = 0x01
+	}
+	return tcn.LifterBytes(function, 0x00, status, byte(b.lifterError))
+}
+
+func (b *Board) replyAfter(data []byte) {
+	b.after(0, func() {
+		b.reply(data)
+	})
+}
+
+func (b *Board) after(d time.Duration, f func()) {
+	delay := b.Delay
+	if delay == 0 {
+		delay = 20 * time.Millisecond
+	}
+	time.AfterFunc(delay+d, f)
+}
+
+// reply feeds data back to the host like a serial read loop would.
+func (b *Board) reply(data []byte) {
+	b.replyMu.Lock()
+	defer b.replyMu.Unlock()
+	if b.Faults == nil {
+		b.received = tcn.Process(append(b.received, data...), &b.channels)
+		return
+	}
+	b.Faults.Inbound(data, func(chunk []byte) {
+		b.received = tcn.Process(append(b.received, chunk...), &b.channels)
+	})
+}
+
+// basicReply returns the 0x00 0x5D reply frame.
+func basicReply(first, second byte) []byte {
+	out := []byte{0x00, 0x5D, first, second}
+	var sum byte
+	for _, c := range out {
+		sum += c
+	}
+	return append(out, sum)
+}
+
+func xor(data []byte) (out byte) {
+	for _, c := range data {
+		out ^= c
+	}
+	return
+}
@@ -0,0 +1,110 @@
+package sim_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/tcn/jsonrpc"
+	"github.com/caiguanhao/vending-processors/tcn/sim"
+)
+
+func newTCN() (*jsonrpc.TCN, *sim.Board) {
+	board := sim.NewBoard()
+	board.RotateDuration = 50 * time.Millisecond
+	board.ShipDuration = 100 * time.Millisecond
+	clients := &sync.Map{}
+	clients.Store("a", board)
+	return &jsonrpc.TCN{Clients: clients}, board
+}
+
+func TestBoardBasic(t *testing.T) {
+	s, board := newTCN()
+	args := &jsonrpc.BasicArgs{ClientID: "a"}
+	var ok bool
+	if err := s.Check(args, &ok); err != nil || !ok {
+		t.Errorf("check: ok = %v, err = %v", ok, err)
+	}
+	board.SetTemperature(7)
+	var status jsonrpc.StatusReply
+	if err := s.Status(args, &status); err != nil || status.ActualTemperature != 7 {
+		t.Errorf("status = %+v, err = %v", status, err)
+	}
+	for _, command := range []func(*jsonrpc.BasicArgs, *bool) error{s.TurnOnHeater, s.TurnOnLights} {
+		if err := command(args, &ok); err != nil || !ok {
+			t.Errorf("ok = %v, err = %v", ok, err)
+		}
+	}
+	if err := s.TurnOnRefrigerator(&jsonrpc.TurnOnRefrigeratorArgs{ClientID: "a", Temperature: 4}, &ok); err != nil || !ok {
+		t.Errorf("refrigerator: ok = %v, err = %v", ok, err)
+	}
+	state := board.State()
+	if !state.Heater || !state.Lights || !state.Cooling || state.TargetTemperature != 4 {
+		t.Errorf("state = %+v", state)
+	}
+}
+
+func TestBoardRotate(t *testing.T) {
+	s, board := newTCN()
+	board.SetStock(3, 1)
+	board.Jam(4, true)
+	tests := []struct {
+		number int
+		ok     bool
+	}{
+		{3, true},
+		{3, false}, // empty
+		{4, false}, // jammed
+	}
+	for _, test := range tests {
+		var ok bool
+		if err := s.Rotate(&jsonrpc.RotateArgs{BasicArgs: jsonrpc.BasicArgs{ClientID: "a"}, Number: test.number}, &ok); err != nil || ok != test.ok {
+			t.Errorf("slot %d: ok = %v, err = %v, want %v", test.number, ok, err, test.ok)
+		}
+	}
+	if stock := board.Stock(3); stock != 0 {
+		t.Errorf("stock = %d, want 0", stock)
+	}
+}
+
+func TestBoardDefaults(t *testing.T) {
+	board := &sim.Board{}
+	if stock := board.Stock(60); stock != 10 {
+		t.Errorf("stock of slot 60 = %d, want 10", stock)
+	}
+	if stock := board.Stock(61); stock != 0 {
+		t.Errorf("stock of slot 61 = %d, want 0", stock)
+	}
+}
+
+func TestBoardLifter(t *testing.T) {
+	s, board := newTCN()
+	board.Jam(2, true)
+	var reply jsonrpc.LifterStatusReply
+	if err := s.LifterShip(&jsonrpc.LifterShipArgs{BasicArgs: jsonrpc.BasicArgs{ClientID: "a"}, Number: 1}, &reply); err != nil || !reply.OK {
+		t.Fatalf("ship: reply = %+v, err = %v", reply, err)
+	}
+	var existence jsonrpc.LifterExistenceReply
+	if err := s.LifterCheckExistence(&jsonrpc.BasicArgs{ClientID: "a"}, &existence); err != nil || existence.Exists == nil || !*existence.Exists {
+		t.Errorf("existence = %+v, err = %v", existence, err)
+	}
+	board.Collect()
+	err := s.LifterShip(&jsonrpc.LifterShipArgs{BasicArgs: jsonrpc.BasicArgs{ClientID: "a"}, Number: 2}, &reply)
+	if err != nil || reply.OK || reply.Error == nil || *reply.Error != tcn.LIFTER_ERROR_GOODS_STUCK {
+		t.Errorf("jammed: reply = %+v, err = %v", reply, err)
+	}
+	if err := s.LifterClearErrors(&jsonrpc.BasicArgs{ClientID: "a"}, &reply); err != nil || !reply.OK {
+		t.Errorf("clear errors: reply = %+v, err = %v", reply, err)
+	}
+}
+
+func TestBoardGarbage(t *testing.T) {
+	s, board := newTCN()
+	// stray bytes and a broken frame before a command are skipped
+	board.Write([]byte{0x55, 0x02, 0x01, 0x00, 0xFF})
+	var ok bool
+	if err := s.Check(&jsonrpc.BasicArgs{ClientID: "a"}, &ok); err != nil || !ok {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+}
@@ -0,0 +1,83 @@
+package tcn
+
+import (
+	"context"
+	"sort"
+
+	"github.com/caiguanhao/vending-processors/slots"
+)
+
+var (
+	ErrSlotDisabled   = slots.ErrDisabled
+	ErrSlotNotTracked = slots.ErrNotTracked
+)
+
+type (
+	// SlotHealth keeps the statistics of the slots of every client from the
+	// results of Rotate and LifterShip, and disables a slot after too many
+	// failures in a row. Commands to a disabled slot fail with
+	// ErrSlotDisabled until Enable is called.
+	SlotHealth struct {
+		// consecutive failures before a slot is disabled, defaults to 3,
+		// negative means never
+		MaxFailures int
+		// optional, called when a slot is disabled
+		OnDisable func(clientId string, stats SlotStats)
+
+		health slots.Health
+	}
+
+	// SlotStats is the slots.Stats of a slot. AverageDuration is from the
+	// command to its result, LastErrorCode is the error code of the lifter
+	// or the reply of the board in hex.
+	SlotStats struct {
+		Number int
+		slots.Stats
+	}
+)
+
+// Stats returns the statistics of the slots of the client, sorted by number.
+func (h *SlotHealth) Stats(clientId string) []SlotStats {
+	list := []SlotStats{}
+	for number, stats := range h.health.All(clientId) {
+		list = append(list, SlotStats{number.(int), stats})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Number < list[j].Number
+	})
+	return list
+}
+
+func (h *SlotHealth) Get(clientId string, number int) (SlotStats, bool) {
+	stats, ok := h.health.Get(clientId, number)
+	return SlotStats{number, stats}, ok
+}
+
+func (h *SlotHealth) Disabled(clientId string, number int) bool {
+	return h.health.Disabled(clientId, number)
+}
+
+// Enable enables the slot and resets its consecutive failures, e.g. after
+// it has been fixed. It returns ErrSlotNotTracked if no command has been
+// recorded for the slot.
+func (h *SlotHealth) Enable(clientId string, number int) error {
+	return h.health.Enable(clientId, number)
+}
+
+// reserve waits for other commands to the slot, see slots.Health.Reserve.
+func (h *SlotHealth) reserve(ctx context.Context, clientId string, number int) (func(), error) {
+	release, err := h.health.Reserve(ctx, clientId, number)
+	if err != nil && err != ErrSlotDisabled {
+		err = contextError(ctx)
+	}
+	return release, err
+}
+
+// record adds the result of a command to the slot, duration is negative if
+// the command timed out and errorCode is ignored if ok is true.
+func (h *SlotHealth) record(clientId string, number, duration int, ok bool, errorCode string) {
+	stats, disabled := h.health.Record(clientId, number, h.MaxFailures, duration, ok, errorCode)
+	if disabled && h.OnDisable != nil {
+		h.OnDisable(clientId, SlotStats{number, stats})
+	}
+}
@@ -0,0 +1,124 @@
+package tcn_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+func TestSlotHealth(t *testing.T) {
+	m, board := newMachine()
+	var disabled []tcn.SlotStats
+	m.SlotHealth = &tcn.SlotHealth{
+		MaxFailures: 2,
+		OnDisable: func(clientId string, stats tcn.SlotStats) {
+			disabled = append(disabled, stats)
+		},
+	}
+	ctx := context.Background()
+	if err := m.SlotHealth.Enable("a", 4); err != tcn.ErrSlotNotTracked {
+		t.Errorf("enable untracked: err = %v, want ErrSlotNotTracked", err)
+	}
+	m.Rotate(ctx, 1)
+	board.Jam(4, true)
+	for i := 0; i < 2; i++ {
+		if ok, err := m.Rotate(ctx, 4); err != nil || ok {
+			t.Fatalf("ok = %v, err = %v", ok, err)
+		}
+	}
+	if _, err := m.Rotate(ctx, 4); err != tcn.ErrSlotDisabled {
+		t.Errorf("err = %v, want ErrSlotDisabled", err)
+	}
+	stats, _ := m.SlotHealth.Get("a", 4)
+	if len(disabled) != 1 || !stats.Disabled || stats.Attempts != 2 || stats.ConsecutiveFailures != 2 {
+		t.Errorf("disabled = %+v, stats = %+v", disabled, stats)
+	}
+	// milliseconds from the command to the reply
+	if stats.AverageDuration < 40 || stats.AverageDuration > 1000 {
+		t.Errorf("average duration = %v", stats.AverageDuration)
+	}
+	if err := m.SlotHealth.Enable("a", 4); err != nil {
+		t.Fatal(err)
+	}
+	board.Jam(4, false)
+	if ok, err := m.Rotate(ctx, 4); err != nil || !ok {
+		t.Errorf("after enable: ok = %v, err = %v", ok, err)
+	}
+	if list := m.SlotHealth.Stats("a"); len(list) != 2 || list[0].Number != 1 || list[1].Number != 4 {
+		t.Errorf("stats = %+v", list)
+	}
+}
+
+func TestSlotHealthConcurrent(t *testing.T) {
+	m, board := newMachine()
+	m.Queue = &tcn.Queue{}
+	m.SlotHealth = &tcn.SlotHealth{MaxFailures: 1}
+	board.Jam(5, true)
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Rotate(context.Background(), 5)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	sent := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			sent++
+		case tcn.ErrSlotDisabled:
+		default:
+			t.Errorf("err = %v", err)
+		}
+	}
+	// the others wait for the first one, which disables the slot
+	if stats, _ := m.SlotHealth.Get("a", 5); sent != 1 || stats.Attempts != 1 {
+		t.Errorf("sent = %d, stats = %+v", sent, stats)
+	}
+}
+
+func TestSlotHealthWait(t *testing.T) {
+	m, _ := newMachine()
+	m.Queue = &tcn.Queue{}
+	m.SlotHealth = &tcn.SlotHealth{}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if ok, err := m.Rotate(context.Background(), 5); err != nil || !ok {
+				t.Errorf("ok = %v, err = %v", ok, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats, _ := m.SlotHealth.Get("a", 5); stats.Attempts != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
+
+func TestSlotHealthWaitDone(t *testing.T) {
+	m, _ := newMachine()
+	m.Queue = &tcn.Queue{}
+	m.SlotHealth = &tcn.SlotHealth{}
+	done := make(chan struct{})
+	go func() {
+		m.Rotate(context.Background(), 5)
+		close(done)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	if _, err := m.Rotate(ctx, 5); err != tcn.ErrTimeout {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+	<-done
+}
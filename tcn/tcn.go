@@ -1,9 +1,10 @@
 package tcn
 
 import (
-	"bytes"
+	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -15,17 +16,25 @@ const (
 	FUNC_LIFTER_RESET_LIFTER    = 0x05 // CMD_LIFTER_BACK_HOME
 	FUNC_LIFTER_OPERATE_SHUTTER = 0x06 // CMD_CLAPBOARD_SWITCH
 	FUNC_LIFTER_CLEAR_ERRORS    = 0x50 // CMD_CLEAN_FAULTS
+	FUNC_LIFTER_GET_PARAMETERS  = 0x51 // CMD_QUERY_PARAMETERS
+	FUNC_LIFTER_GET_DRIVER      = 0x52 // CMD_QUERY_DRIVER_CMD
+	FUNC_LIFTER_DETECT_LIGHT    = 0x84 // CMD_DETECT_LIGHT
 	FUNC_LIFTER_CHECK_EXISTENCE = 0x85 // CMD_DETECT_SHIP
+	FUNC_LIFTER_DETECT_INPUT    = 0x86 // CMD_DETECT_SWITCH_INPUT
 
-	KEY_DEFAULT = "default"
-	KEY_STATUS  = "status"
-	KEY_SHIP    = "ship"
-	KEY_TRAY    = "tray"
-	KEY_MOVE    = "move"
-	KEY_RESET   = "reset"
-	KEY_SHUTTER = "shutter"
-	KEY_CLEAR   = "clear"
-	KEY_EXIST   = "exist"
+	KEY_DEFAULT    = "default"
+	KEY_STATUS     = "status"
+	KEY_SHIP       = "ship"
+	KEY_TRAY       = "tray"
+	KEY_MOVE       = "move"
+	KEY_RESET      = "reset"
+	KEY_SHUTTER    = "shutter"
+	KEY_CLEAR      = "clear"
+	KEY_GET_PARAMS = "getparams"
+	KEY_DRIVER     = "driver"
+	KEY_LIGHT      = "light"
+	KEY_EXIST      = "exist"
+	KEY_INPUT      = "input"
 )
 
 var (
@@ -37,76 +46,125 @@ var (
 		FUNC_LIFTER_RESET_LIFTER:    KEY_RESET,
 		FUNC_LIFTER_OPERATE_SHUTTER: KEY_SHUTTER,
 		FUNC_LIFTER_CLEAR_ERRORS:    KEY_CLEAR,
+		FUNC_LIFTER_GET_PARAMETERS:  KEY_GET_PARAMS,
+		FUNC_LIFTER_GET_DRIVER:      KEY_DRIVER,
+		FUNC_LIFTER_DETECT_LIGHT:    KEY_LIGHT,
 		FUNC_LIFTER_CHECK_EXISTENCE: KEY_EXIST,
+		FUNC_LIFTER_DETECT_INPUT:    KEY_INPUT,
 	}
 )
 
 func Process(_data []byte, multiChannels ...*sync.Map) []byte {
-	_data = processBasic(_data, multiChannels...)
-	_data = processLifter(_data, multiChannels...)
-	return _data
+	return scan(_data, func(frameType int, data []byte) {
+		log.Println("received", data)
+		Frame{Type: frameType, Bytes: data}.Dispatch(multiChannels...)
+	})
 }
 
-func processBasic(_data []byte, multiChannels ...*sync.Map) []byte {
-	for i := 0; i < len(_data)-2; i++ {
-		if _data[i] != 0x00 || _data[i+1] != 0x5d {
-			continue
-		}
-		size := 5
-		if i+size > len(_data) {
-			continue
-		}
-		data := _data[i : i+size]
-		if !validData(data) {
-			continue
-		}
-		index := bytes.Index(_data[i+size:], []byte{0x00, 0x5d})
-		if index == -1 {
-			_data = []byte{}
-		} else {
-			_data = _data[i+size+index:]
-			i = -1 // i will be reset to 0 after this loop
-		}
-		log.Println("received", data)
-		for _, channels := range multiChannels {
-			if channel, ok := channels.Load(KEY_DEFAULT); ok {
-				channel.(chan []byte) <- data
+// FunctionKey returns the channel key of the lifter function, which is
+// "function-" and the hex code for functions without a KEY_* constant.
+func FunctionKey(function byte) string {
+	if key, ok := func2key[function]; ok {
+		return key
+	}
+	return fmt.Sprintf("function-%02x", function)
+}
+
+// scan calls found for every 0x00 0x5D reply and 0x02 ... 0x03 lifter reply
+// in _data, in the order they start, and returns the bytes that are left for
+// the next read: from the first partial frame after the last reply found,
+// or none if there is no partial frame.
+func scan(_data []byte, found func(int, []byte)) []byte {
+	rest := -1
+	for i := 0; i < len(_data); {
+		frameType, size := frameAt(_data[i:])
+		switch {
+		case size > 0:
+			found(frameType, _data[i:i+size])
+			i += size
+			rest = -1
+		case size < 0:
+			if rest == -1 {
+				rest = i
 			}
+			i++
+		default:
+			i++
 		}
 	}
-	return _data
+	if rest == -1 {
+		return []byte{}
+	}
+	return _data[rest:]
 }
 
-func processLifter(_data []byte, multiChannels ...*sync.Map) []byte {
-	for i := 0; i < len(_data)-1; i++ {
-		if _data[i] != 0x02 {
-			continue
+// frameAt returns the type and size of the reply at the start of data, size
+// is 0 if there is none, or -1 if more bytes are needed to tell.
+func frameAt(data []byte) (frameType, size int) {
+	switch data[0] {
+	case 0x00:
+		if len(data) < 2 {
+			return FRAME_BASIC, -1
 		}
-		size := 2 + int(_data[i+1]) + 2
-		if i+size > len(_data) {
-			continue
+		if data[1] != 0x5d {
+			return FRAME_BASIC, 0
 		}
-		data := _data[i : i+size]
-		if data[len(data)-2] != 0x03 {
-			continue
+		if len(data) < 5 {
+			return FRAME_BASIC, -1
 		}
-		index := bytes.IndexByte(_data[i+size:], 0x02)
-		if index == -1 {
-			_data = []byte{}
-		} else {
-			_data = _data[i+size+index:]
-			i = -1 // i will be reset to 0 after this loop
+		if validData(data[:5]) {
+			return FRAME_BASIC, 5
 		}
-		log.Println("received", data)
-		if key, ok := func2key[data[2]]; ok {
-			for _, channels := range multiChannels {
-				if channel, ok := channels.Load(key); ok {
-					channel.(chan []byte) <- data
-				}
-			}
+	case 0x02:
+		if len(data) < 2 {
+			return FRAME_LIFTER, -1
+		}
+		if data[1] < 2 { // function code and checksum at least
+			return FRAME_LIFTER, 0
 		}
+		size = 2 + int(data[1]) + 2
+		if len(data) < size {
+			return FRAME_LIFTER, -1
+		}
+		if data[size-2] == 0x03 {
+			return FRAME_LIFTER, size
+		}
+	}
+	return 0, 0
+}
+
+func dispatch(key string, data []byte, multiChannels ...*sync.Map) {
+	for _, channels := range multiChannels {
+		if channel, ok := channels.Load(key); ok {
+			send(channels, channel.(chan []byte), data)
+		}
+	}
+}
+
+// droppedKey is where the channels of a client keep the number of its
+// dropped replies, it is not a string so it never clashes with a reply key.
+type droppedKey struct{}
+
+// Dropped returns the number of replies Process has dropped for the client
+// with the channels.
+func Dropped(channels *sync.Map) uint64 {
+	if counter, ok := channels.Load(droppedKey{}); ok {
+		return atomic.LoadUint64(counter.(*uint64))
+	}
+	return 0
+}
+
+// send drops data if the reply of the command has already been received,
+// e.g. a duplicated reply, so that the read loop is never blocked. Every drop
+// is logged and counted in Dropped.
+func send(channels *sync.Map, channel chan []byte, data []byte) {
+	select {
+	case channel <- data:
+	default:
+		counter, _ := channels.LoadOrStore(droppedKey{}, new(uint64))
+		n := atomic.AddUint64(counter.(*uint64), 1)
+		log.Printf("dropped reply #%d: % X", n, data)
 	}
-	return _data
 }
 
 func validData(input []byte) bool {
@@ -0,0 +1,3 @@
+// Package serial is a Client of tcn/jsonrpc and ziman/jsonrpc on a serial
+// port. It is only available on Linux, as it sets the port up with termios.
+package serial
@@ -0,0 +1,292 @@
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/caiguanhao/vending-processors/probe"
+	"github.com/caiguanhao/vending-processors/transport"
+)
+
+const (
+	PARITY_NONE = "none"
+	PARITY_ODD  = "odd"
+	PARITY_EVEN = "even"
+
+	// not in package syscall
+	cbaud = 0x100f
+)
+
+var (
+	ErrUnsupportedBaud     = errors.New("unsupported baud rate")
+	ErrUnsupportedParity   = errors.New("unsupported parity")
+	ErrUnsupportedStopBits = errors.New("unsupported stop bits")
+
+	bauds = map[int]uint32{
+		1200:   syscall.B1200,
+		2400:   syscall.B2400,
+		4800:   syscall.B4800,
+		9600:   syscall.B9600,
+		19200:  syscall.B19200,
+		38400:  syscall.B38400,
+		57600:  syscall.B57600,
+		115200: syscall.B115200,
+		230400: syscall.B230400,
+	}
+)
+
+type (
+	// Config of the port, data bits are always 8.
+	Config struct {
+		// e.g. /dev/ttyUSB0
+		Device string
+		// defaults to 9600
+		Baud int
+		// PARITY_*, defaults to PARITY_NONE
+		Parity string
+		// 1 or 2, defaults to 1
+		StopBits int
+		// milliseconds between attempts to reopen the device, defaults to
+		// 1000
+		ReconnectInterval int
+		// optional, called with Device when the device is opened or
+		// reopened and when it is gone, e.g. to Add and Remove the port
+		// from a registry.Registry
+		OnConnect    func(id string, p *Port)
+		OnDisconnect func(id string, p *Port)
+	}
+
+	// Port implements the Client interface. It runs the read loop with
+	// Processor and reopens the device when it is gone, e.g. a USB adapter
+	// that is unplugged and plugged back in.
+	Port struct {
+		Config    Config
+		Processor transport.Processor
+
+		channels sync.Map
+
+		mu       sync.Mutex
+		file     *os.File
+		closed   bool
+		done     chan struct{}
+		detected probe.Result
+	}
+)
+
+// Open opens the device and starts the read loop, processor is tcn.Process
+// or ziman.Process. If processor is nil, the protocol is detected with
+// probe.Probe.
+func Open(config Config, processor transport.Processor) (*Port, error) {
+	if config.Baud == 0 {
+		config.Baud = 9600
+	}
+	if config.Parity == "" {
+		config.Parity = PARITY_NONE
+	}
+	if config.StopBits == 0 {
+		config.StopBits = 1
+	}
+	if config.ReconnectInterval == 0 {
+		config.ReconnectInterval = 1000
+	}
+	file, err := open(config)
+	if err != nil {
+		return nil, err
+	}
+	var detected probe.Result
+	if processor == nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		detected, err = probe.Probe(ctx, file, probe.Options{})
+		cancel()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", config.Device, err)
+		}
+		processor = detected.Processor
+	}
+	p := &Port{
+		Config:    config,
+		Processor: processor,
+		file:      file,
+		done:      make(chan struct{}),
+		detected:  detected,
+	}
+	if config.OnConnect != nil {
+		config.OnConnect(config.Device, p)
+	}
+	go p.run(file)
+	return p, nil
+}
+
+func (p *Port) GetChannels() *sync.Map {
+	return &p.channels
+}
+
+// Write returns transport.ErrNotConnected while the device is being reopened.
+func (p *Port) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return 0, transport.ErrClosed
+	}
+	if p.file == nil {
+		return 0, transport.ErrNotConnected
+	}
+	return p.file.Write(b)
+}
+
+// Detected returns the result of probe.Probe, which is empty if Open was given
+// a processor.
+func (p *Port) Detected() probe.Result {
+	return p.detected
+}
+
+func (p *Port) Connected() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.file != nil
+}
+
+// Close closes the device and stops the read loop.
+func (p *Port) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+func (p *Port) run(file *os.File) {
+	for {
+		err := transport.Pump(file, p.Processor, &p.channels)
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return
+		}
+		p.file = nil
+		file.Close()
+		p.mu.Unlock()
+		log.Println(p.Config.Device, "disconnected:", err)
+		if p.Config.OnDisconnect != nil {
+			p.Config.OnDisconnect(p.Config.Device, p)
+		}
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-time.After(time.Duration(p.Config.ReconnectInterval) * time.Millisecond):
+			}
+			file, err = open(p.Config)
+			if err == nil {
+				break
+			}
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			file.Close()
+			return
+		}
+		p.file = file
+		p.mu.Unlock()
+		log.Println(p.Config.Device, "reconnected")
+		if p.Config.OnConnect != nil {
+			p.Config.OnConnect(p.Config.Device, p)
+		}
+	}
+}
+
+func open(config Config) (*os.File, error) {
+	file, err := os.OpenFile(config.Device, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := file.SyscallConn()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	var termiosErr error
+	err = conn.Control(func(fd uintptr) {
+		termiosErr = setTermios(fd, config)
+	})
+	if err == nil {
+		err = termiosErr
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", config.Device, err)
+	}
+	return file, nil
+}
+
+// setTermios puts the port in raw mode with the baud rate, parity and stop
+// bits of config.
+func setTermios(fd uintptr, config Config) error {
+	speed, ok := bauds[config.Baud]
+	if !ok {
+		return ErrUnsupportedBaud
+	}
+	var t syscall.Termios
+	if err := ioctl(fd, syscall.TCGETS, &t); err != nil {
+		return err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF |
+		syscall.IXANY | syscall.INPCK
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | cbaud
+	t.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL | speed
+	switch config.Parity {
+	case PARITY_NONE:
+	case PARITY_EVEN:
+		t.Cflag |= syscall.PARENB
+		t.Iflag |= syscall.INPCK
+	case PARITY_ODD:
+		t.Cflag |= syscall.PARENB | syscall.PARODD
+		t.Iflag |= syscall.INPCK
+	default:
+		return ErrUnsupportedParity
+	}
+	switch config.StopBits {
+	case 1:
+	case 2:
+		t.Cflag |= syscall.CSTOPB
+	default:
+		return ErrUnsupportedStopBits
+	}
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	t.Ispeed = speed
+	t.Ospeed = speed
+	return ioctl(fd, syscall.TCSETS, &t)
+}
+
+func ioctl(fd uintptr, request uintptr, t *syscall.Termios) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(t)))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
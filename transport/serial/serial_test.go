@@ -0,0 +1,130 @@
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/caiguanhao/vending-processors/registry"
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/transport"
+)
+
+// openPty returns the master of a pseudo terminal and the device of its
+// slave, which acts like a serial port.
+func openPty(t *testing.T) (*os.File, string) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		t.Skip("no pseudo terminal:", err)
+	}
+	var n uint32
+	var unlock int32
+	for _, call := range []struct {
+		request uintptr
+		arg     unsafe.Pointer
+	}{
+		{syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)},
+		{syscall.TIOCGPTN, unsafe.Pointer(&n)},
+	} {
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), call.request, uintptr(call.arg)); errno != 0 {
+			master.Close()
+			t.Skip("no pseudo terminal:", errno)
+		}
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n)
+}
+
+func TestPort(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+	p, err := Open(Config{Device: device, Baud: 19200, Parity: PARITY_EVEN, StopBits: 2}, tcn.Process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	if !p.Connected() {
+		t.Error("not connected")
+	}
+	// the board
+	go func() {
+		buf := make([]byte, 6)
+		if _, err := master.Read(buf); err == nil {
+			master.Write([]byte{0x00, 0x5D, 0x00, 0x00, 0x5D})
+		}
+	}()
+	m := &tcn.Machine{Client: p, HideLogs: true}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := m.Check(ctx); err != nil {
+		t.Errorf("check: %v", err)
+	}
+}
+
+func TestPortDisconnect(t *testing.T) {
+	master, device := openPty(t)
+	r := registry.New()
+	p, err := Open(Config{
+		Device:            device,
+		ReconnectInterval: 10,
+		OnConnect: func(id string, p *Port) {
+			r.Add(id, p, registry.Metadata{})
+		},
+		OnDisconnect: func(id string, p *Port) {
+			r.Remove(id)
+		},
+	}, tcn.Process)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := r.Get(device); !ok || !info.Connected {
+		t.Errorf("info = %+v, want connected", info)
+	}
+	// like unplugging the adapter
+	master.Close()
+	deadline := time.Now().Add(time.Second)
+	for {
+		if info, ok := r.Get(device); ok && !info.Connected {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("still connected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p.Connected() {
+		t.Error("still connected")
+	}
+	if _, err := p.Write([]byte{0x00}); err != transport.ErrNotConnected {
+		t.Errorf("err = %v, want ErrNotConnected", err)
+	}
+	p.Close()
+	if _, err := p.Write([]byte{0x00}); err != transport.ErrClosed {
+		t.Errorf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestOpenUnsupported(t *testing.T) {
+	master, device := openPty(t)
+	defer master.Close()
+	tests := []struct {
+		config Config
+		err    error
+	}{
+		{Config{Device: device, Baud: 12345}, ErrUnsupportedBaud},
+		{Config{Device: device, Parity: "mark"}, ErrUnsupportedParity},
+		{Config{Device: device, StopBits: 3}, ErrUnsupportedStopBits},
+	}
+	for _, test := range tests {
+		if _, err := Open(test.config, tcn.Process); !errors.Is(err, test.err) {
+			t.Errorf("err = %v, want %v", err, test.err)
+		}
+	}
+}
@@ -0,0 +1,388 @@
+// Package tcp is a Client of tcn/jsonrpc and ziman/jsonrpc over TCP, e.g. for
+// boards behind RS-485-to-Ethernet converters.
+package tcp
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/probe"
+	"github.com/caiguanhao/vending-processors/transport"
+)
+
+type (
+	Config struct {
+		// address to connect to with Dial, or to listen on with Listen
+		Address string
+		// seconds between keep-alive probes, defaults to 15, negative
+		// disables keep-alive
+		KeepAlive int
+		// milliseconds before the first reconnect of Dial, doubled after each
+		// failure or disconnect up to MaxBackoff, defaults to 500
+		Backoff int
+		// defaults to 30000
+		MaxBackoff int
+		// milliseconds a connection of Dial has to stay up before the
+		// backoff goes back to Backoff, defaults to 10000
+		Stable int
+		// optional, returns the client_id of a connection accepted by
+		// Listen. If it is nil the remote address (host and port) is used,
+		// which changes on every reconnect, so a board that has to keep its
+		// client_id needs a Handshake.
+		Handshake func(net.Conn) (string, error)
+		// milliseconds Handshake has to finish in, defaults to 5000
+		HandshakeTimeout int
+		// optional, called when a connection of Dial is up and when it is
+		// lost, or when Listen stores a connection in Clients and when it
+		// deletes it, e.g. to Add and Remove it from a registry.Registry
+		OnConnect    func(id string, c *Conn)
+		OnDisconnect func(id string, c *Conn)
+	}
+
+	// Conn implements the Client interface.
+	Conn struct {
+		ID string
+
+		config    Config
+		processor transport.Processor
+		channels  sync.Map
+
+		mu       sync.Mutex
+		conn     net.Conn
+		closed   bool
+		done     chan struct{}
+		detected probe.Result
+	}
+
+	// Listener accepts connections from boards and stores each of them in
+	// Clients under its client_id, until it is disconnected. A board that
+	// connects again replaces its previous connection.
+	Listener struct {
+		Config  Config
+		Clients *sync.Map
+
+		processor transport.Processor
+
+		listener net.Listener
+		mu       sync.Mutex
+		closed   bool
+		conns    map[string]*Conn
+	}
+)
+
+// Dial returns a Conn with Address as its ID, which connects in the background
+// and reconnects with backoff when the connection is lost. processor is
+// tcn.Process or ziman.Process, if it is nil the protocol is detected with
+// probe.Probe on each connection.
+func Dial(config Config, processor transport.Processor) *Conn {
+	config = withDefaults(config)
+	c := &Conn{
+		ID:        config.Address,
+		config:    config,
+		processor: processor,
+		done:      make(chan struct{}),
+	}
+	go c.dial()
+	return c
+}
+
+// Listen accepts connections on Address in the background, if processor is
+// nil the protocol of each connection is detected with probe.Probe.
+func Listen(config Config, processor transport.Processor, clients *sync.Map) (*Listener, error) {
+	config = withDefaults(config)
+	listener, err := net.Listen("tcp", config.Address)
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		Config:    config,
+		processor: processor,
+		Clients:   clients,
+		listener:  listener,
+		conns:     map[string]*Conn{},
+	}
+	go l.accept()
+	return l, nil
+}
+
+func (c *Conn) GetChannels() *sync.Map {
+	return &c.channels
+}
+
+// Write returns transport.ErrNotConnected while Dial is reconnecting.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, transport.ErrClosed
+	}
+	if c.conn == nil {
+		return 0, transport.ErrNotConnected
+	}
+	return c.conn.Write(b)
+}
+
+func (c *Conn) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Detected returns the result of probe.Probe of the current connection, which
+// is empty if a processor was given.
+func (c *Conn) Detected() probe.Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.detected
+}
+
+// RemoteAddr returns nil if it is not connected.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil
+	}
+	return c.conn.RemoteAddr()
+}
+
+// Close closes the connection, Dial stops reconnecting.
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *Conn) dial() {
+	dialer := net.Dialer{
+		KeepAlive: time.Duration(c.config.KeepAlive) * time.Second,
+	}
+	backoff := c.config.Backoff
+	for {
+		if up, ok := c.connect(dialer); !ok {
+			return
+		} else if up >= time.Duration(c.config.Stable)*time.Millisecond {
+			backoff = c.config.Backoff
+		}
+		select {
+		case <-c.done:
+			return
+		case <-time.After(time.Duration(backoff) * time.Millisecond):
+		}
+		backoff *= 2
+		if backoff > c.config.MaxBackoff {
+			backoff = c.config.MaxBackoff
+		}
+	}
+}
+
+// connect connects and pumps until the connection is lost, it returns how
+// long the connection was up and false if c has been closed.
+func (c *Conn) connect(dialer net.Dialer) (time.Duration, bool) {
+	conn, err := dialer.Dial("tcp", c.config.Address)
+	if err != nil {
+		log.Println(c.ID, "error connecting:", err)
+		return 0, true
+	}
+	processor, detected, err := detect(conn, c.processor)
+	if err != nil {
+		log.Println(c.ID, "error detecting protocol:", err)
+		conn.Close()
+		return 0, true
+	}
+	if !c.setConn(conn, detected) {
+		return 0, false
+	}
+	log.Println(c.ID, "connected")
+	if c.config.OnConnect != nil {
+		c.config.OnConnect(c.ID, c)
+	}
+	start := time.Now()
+	err = c.pump(conn, processor)
+	log.Println(c.ID, "disconnected:", err)
+	if c.config.OnDisconnect != nil {
+		c.config.OnDisconnect(c.ID, c)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return time.Since(start), !c.closed
+}
+
+// setConn returns false if c has been closed.
+func (c *Conn) setConn(conn net.Conn, detected probe.Result) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		conn.Close()
+		return false
+	}
+	c.conn = conn
+	c.detected = detected
+	return true
+}
+
+func (c *Conn) pump(conn net.Conn, processor transport.Processor) error {
+	err := transport.Pump(conn, processor, &c.channels)
+	c.mu.Lock()
+	if c.conn == conn {
+		c.conn = nil
+	}
+	c.mu.Unlock()
+	conn.Close()
+	return err
+}
+
+// Close stops accepting and closes all connections. OnDisconnect is called
+// after the lock is released, so it may use the Listener.
+func (l *Listener) Close() error {
+	err := l.listener.Close()
+	l.mu.Lock()
+	l.closed = true
+	conns := l.conns
+	l.conns = map[string]*Conn{}
+	for id, c := range conns {
+		c.Close()
+		l.Clients.Delete(id)
+	}
+	l.mu.Unlock()
+	if l.Config.OnDisconnect != nil {
+		for id, c := range conns {
+			l.Config.OnDisconnect(id, c)
+		}
+	}
+	return err
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
+func (l *Listener) accept() {
+	for {
+		conn, err := l.listener.Accept()
+		if err != nil {
+			log.Println("error accepting:", err)
+			return
+		}
+		go l.serve(conn)
+	}
+}
+
+func (l *Listener) serve(conn net.Conn) {
+	if tcpConn, ok := conn.(*net.TCPConn); ok && l.Config.KeepAlive > 0 {
+		tcpConn.SetKeepAlive(true)
+		tcpConn.SetKeepAlivePeriod(time.Duration(l.Config.KeepAlive) * time.Second)
+	}
+	id, err := l.clientId(conn)
+	if err != nil {
+		log.Println(conn.RemoteAddr(), "handshake failed:", err)
+		conn.Close()
+		return
+	}
+	processor, detected, err := detect(conn, l.processor)
+	if err != nil {
+		log.Println(id, "error detecting protocol:", err)
+		conn.Close()
+		return
+	}
+	c := &Conn{
+		ID:        id,
+		config:    l.Config,
+		processor: processor,
+		conn:      conn,
+		done:      make(chan struct{}),
+		detected:  detected,
+	}
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		conn.Close()
+		return
+	}
+	if previous, ok := l.conns[id]; ok {
+		previous.Close()
+	}
+	l.conns[id] = c
+	l.Clients.Store(id, c)
+	if l.Config.OnConnect != nil {
+		l.Config.OnConnect(id, c)
+	}
+	l.mu.Unlock()
+	log.Println(id, "connected from", conn.RemoteAddr())
+
+	err = c.pump(conn, processor)
+	log.Println(id, "disconnected:", err)
+
+	l.mu.Lock()
+	if l.conns[id] == c {
+		delete(l.conns, id)
+		l.Clients.Delete(id)
+		if l.Config.OnDisconnect != nil {
+			l.Config.OnDisconnect(id, c)
+		}
+	}
+	l.mu.Unlock()
+}
+
+// clientId runs Handshake with a deadline, so a connection that never
+// finishes it is closed.
+func (l *Listener) clientId(conn net.Conn) (string, error) {
+	if l.Config.Handshake == nil {
+		return conn.RemoteAddr().String(), nil
+	}
+	timeout := time.Duration(l.Config.HandshakeTimeout) * time.Millisecond
+	if err := conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return "", err
+	}
+	id, err := l.Config.Handshake(conn)
+	if err != nil {
+		return "", err
+	}
+	return id, conn.SetDeadline(time.Time{})
+}
+
+// detect returns processor, or the detected one if it is nil.
+func detect(conn net.Conn, processor transport.Processor) (transport.Processor, probe.Result, error) {
+	if processor != nil {
+		return processor, probe.Result{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	detected, err := probe.Probe(ctx, conn, probe.Options{})
+	if err != nil {
+		return nil, detected, err
+	}
+	return detected.Processor, detected, nil
+}
+
+func withDefaults(config Config) Config {
+	if config.KeepAlive == 0 {
+		config.KeepAlive = 15
+	}
+	if config.Backoff == 0 {
+		config.Backoff = 500
+	}
+	if config.MaxBackoff == 0 {
+		config.MaxBackoff = 30000
+	}
+	if config.Stable == 0 {
+		config.Stable = 10000
+	}
+	if config.HandshakeTimeout == 0 {
+		config.HandshakeTimeout = 5000
+	}
+	return config
+}
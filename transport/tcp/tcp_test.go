@@ -0,0 +1,230 @@
+package tcp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+// board accepts connections and keeps each of them open for the duration
+// of its index in hold, the rest are closed at once. The returned channel is
+// closed after len(accepts) connections.
+func board(t *testing.T, hold []time.Duration, accepts, closes []time.Time) (string, chan struct{}) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer listener.Close()
+		for i := range accepts {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepts[i] = time.Now()
+			if i < len(hold) {
+				time.Sleep(hold[i])
+			}
+			conn.Close()
+			closes[i] = time.Now()
+		}
+	}()
+	return listener.Addr().String(), done
+}
+
+func wait(t *testing.T, done chan struct{}) {
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("too few reconnects")
+	}
+}
+
+func TestDialBackoff(t *testing.T) {
+	accepts, closes := make([]time.Time, 5), make([]time.Time, 5)
+	address, done := board(t, nil, accepts, closes)
+	c := Dial(Config{Address: address, Backoff: 40, MaxBackoff: 1000, Stable: 1000}, tcn.Process)
+	defer c.Close()
+	wait(t, done)
+	// every disconnect doubles the wait
+	for i, want := range []time.Duration{40, 80, 160, 320} {
+		want *= time.Millisecond
+		if gap := accepts[i+1].Sub(closes[i]); gap < want*4/5 || gap > want*2 {
+			t.Errorf("gap #%d = %s, want %s", i+1, gap, want)
+		}
+	}
+}
+
+func TestDialStable(t *testing.T) {
+	accepts, closes := make([]time.Time, 4), make([]time.Time, 4)
+	hold := []time.Duration{0, 0, 150 * time.Millisecond}
+	address, done := board(t, hold, accepts, closes)
+	c := Dial(Config{Address: address, Backoff: 40, MaxBackoff: 1000, Stable: 100}, tcn.Process)
+	defer c.Close()
+	wait(t, done)
+	if gap := accepts[2].Sub(closes[1]); gap < 64*time.Millisecond {
+		t.Errorf("gap after a short connection = %s, want 80ms", gap)
+	}
+	// the backoff is reset after the stable connection
+	if gap := accepts[3].Sub(closes[2]); gap > 70*time.Millisecond {
+		t.Errorf("gap after a stable connection = %s, want 40ms", gap)
+	}
+}
+
+func TestListen(t *testing.T) {
+	clients := &sync.Map{}
+	events := make(chan string, 2)
+	l, err := Listen(Config{
+		Address: "127.0.0.1:0",
+		Handshake: func(conn net.Conn) (string, error) {
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			return strings.TrimSpace(line), err
+		},
+		OnConnect: func(id string, c *Conn) {
+			events <- "connect " + id
+		},
+		OnDisconnect: func(id string, c *Conn) {
+			events <- "disconnect " + id
+		},
+	}, tcn.Process, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("board-1\n"))
+	if event := <-events; event != "connect board-1" {
+		t.Errorf("event = %s", event)
+	}
+	if _, ok := clients.Load("board-1"); !ok {
+		t.Error("board-1 is not in clients")
+	}
+	conn.Close()
+	if event := <-events; event != "disconnect board-1" {
+		t.Errorf("event = %s", event)
+	}
+	if _, ok := clients.Load("board-1"); ok {
+		t.Error("board-1 is still in clients")
+	}
+}
+
+func TestListenHandshakeTimeout(t *testing.T) {
+	clients := &sync.Map{}
+	l, err := Listen(Config{
+		Address:          "127.0.0.1:0",
+		HandshakeTimeout: 50,
+		Handshake: func(conn net.Conn) (string, error) {
+			line, err := bufio.NewReader(conn).ReadString('\n')
+			return strings.TrimSpace(line), err
+		},
+	}, tcn.Process, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	// the handshake is never sent, so the listener closes the connection
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("read = nil error, want the connection closed")
+	} else if e, ok := err.(net.Error); ok && e.Timeout() {
+		t.Fatal("the connection is still open")
+	}
+}
+
+func TestListenRemoteAddress(t *testing.T) {
+	clients := &sync.Map{}
+	connected := make(chan string, 1)
+	l, err := Listen(Config{
+		Address: "127.0.0.1:0",
+		OnConnect: func(id string, c *Conn) {
+			connected <- id
+		},
+	}, tcn.Process, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if id := <-connected; id != conn.LocalAddr().String() {
+		t.Errorf("id = %s, want %s", id, conn.LocalAddr())
+	}
+}
+
+func TestListenClose(t *testing.T) {
+	clients := &sync.Map{}
+	connected := make(chan string, 1)
+	var l *Listener
+	l, err := Listen(Config{
+		Address: "127.0.0.1:0",
+		OnConnect: func(id string, c *Conn) {
+			connected <- id
+		},
+		OnDisconnect: func(id string, c *Conn) {
+			// would deadlock if it was called with the lock held
+			l.Close()
+		},
+	}, tcn.Process, clients)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	id := <-connected
+	done := make(chan struct{})
+	go func() {
+		l.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close is blocked")
+	}
+	if _, ok := clients.Load(id); ok {
+		t.Errorf("%s is still in clients", id)
+	}
+}
+
+func TestDialEvents(t *testing.T) {
+	accepts, closes := make([]time.Time, 1), make([]time.Time, 1)
+	address, done := board(t, []time.Duration{50 * time.Millisecond}, accepts, closes)
+	events := make(chan string, 2)
+	c := Dial(Config{
+		Address: address,
+		OnConnect: func(id string, c *Conn) {
+			events <- "connect " + id
+		},
+		OnDisconnect: func(id string, c *Conn) {
+			events <- "disconnect " + id
+		},
+	}, tcn.Process)
+	defer c.Close()
+	wait(t, done)
+	for _, want := range []string{"connect " + address, "disconnect " + address} {
+		if event := <-events; event != want {
+			t.Errorf("event = %s, want %s", event, want)
+		}
+	}
+}
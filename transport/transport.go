@@ -0,0 +1,40 @@
+// Package transport has what the serial and tcp clients share: the Processor
+// of each protocol and the read loop that feeds it.
+package transport
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// MAX_BUFFER_SIZE is the number of unprocessed bytes Pump keeps, older bytes
+// are dropped so that garbage on the line can not grow the buffer forever.
+const MAX_BUFFER_SIZE = 4096
+
+var (
+	ErrNotConnected = errors.New("not connected")
+	ErrClosed       = errors.New("closed")
+)
+
+// Processor is tcn.Process or ziman.Process.
+type Processor func([]byte, ...*sync.Map) []byte
+
+// Pump reads r and passes the bytes to process until r returns an error, the
+// bytes process leaves are kept for the next read.
+func Pump(r io.Reader, process Processor, multiChannels ...*sync.Map) error {
+	buf := make([]byte, 1024)
+	var data []byte
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			data = process(append(data, buf[:n]...), multiChannels...)
+			if len(data) > MAX_BUFFER_SIZE {
+				data = append([]byte{}, data[len(data)-MAX_BUFFER_SIZE:]...)
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestPumpBoundedBuffer(t *testing.T) {
+	var kept int
+	process := func(data []byte, multiChannels ...*sync.Map) []byte {
+		if len(data) > MAX_BUFFER_SIZE+1024 {
+			t.Fatalf("buffer = %d bytes, want at most %d", len(data), MAX_BUFFER_SIZE+1024)
+		}
+		kept = len(data)
+		// nothing is ever a frame
+		return data
+	}
+	r := bytes.NewReader(make([]byte, 3*MAX_BUFFER_SIZE))
+	if err := Pump(r, process); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+	if kept < MAX_BUFFER_SIZE {
+		t.Errorf("kept = %d, want at least %d", kept, MAX_BUFFER_SIZE)
+	}
+}
@@ -0,0 +1,239 @@
+package vend
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	RECORD_BEGIN   = "begin"
+	RECORD_END     = "end"
+	RECORD_RESOLVE = "resolve"
+)
+
+var (
+	ErrDuplicateKey = errors.New("idempotency key has been used")
+	ErrNoSuchKey    = errors.New("no such idempotency key")
+	ErrNoKey        = errors.New("idempotency key is required")
+)
+
+type (
+	// Journal is an append-only file of JSON lines. A vend is recorded before
+	// the motor command is sent and again with its outcome, so a vend that
+	// has begun but not ended was interrupted, e.g. by a crash, and it is
+	// not known whether the product dropped. Use OpenJournal to open one.
+	// Only vends run by Vendor.Vend are recorded; motor commands sent in any
+	// other way, e.g. the Rotate, Unlock or LifterShip RPC methods of the
+	// tcn and ziman jsonrpc packages, bypass the journal.
+	Journal struct {
+		mu      sync.Mutex
+		file    *os.File
+		entries map[string]*Entry
+		// lines that could not be read, e.g. the last line written before
+		// a crash
+		corrupted int
+	}
+
+	Entry struct {
+		Key       string    `json:"key"`
+		Slot      int       `json:"slot"`
+		Reference string    `json:"reference"`
+		BeganAt   time.Time `json:"began_at"`
+		// zero if it has not ended
+		EndedAt time.Time `json:"ended_at"`
+		Outcome string    `json:"outcome"`
+		Reason  string    `json:"reason"`
+		// true if it had begun but not ended when the journal was opened
+		InDoubt bool `json:"in_doubt"`
+	}
+
+	record struct {
+		Type      string    `json:"type"`
+		Key       string    `json:"key"`
+		Time      time.Time `json:"time"`
+		Slot      int       `json:"slot,omitempty"`
+		Reference string    `json:"reference,omitempty"`
+		Outcome   string    `json:"outcome,omitempty"`
+		Reason    string    `json:"reason,omitempty"`
+	}
+)
+
+// OpenJournal opens or creates the journal at path and reads the vends that
+// are recorded in it.
+func OpenJournal(path string) (*Journal, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	j := &Journal{
+		file:    file,
+		entries: map[string]*Entry{},
+	}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil || r.Key == "" {
+			j.corrupted++
+			continue
+		}
+		j.apply(r)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := terminate(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+	for _, entry := range j.entries {
+		entry.InDoubt = entry.EndedAt.IsZero()
+	}
+	return j, nil
+}
+
+// Recover returns the vends that were interrupted, sorted by the time they
+// began. Their outcome is unknown until Resolve is called.
+func (j *Journal) Recover() []Entry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := []Entry{}
+	for _, entry := range j.entries {
+		if entry.InDoubt && entry.EndedAt.IsZero() {
+			entries = append(entries, *entry)
+		}
+	}
+	sort.Slice(entries, func(a, b int) bool {
+		return entries[a].BeganAt.Before(entries[b].BeganAt)
+	})
+	return entries
+}
+
+// Corrupted returns the number of lines that could not be read.
+func (j *Journal) Corrupted() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.corrupted
+}
+
+func (j *Journal) Get(key string) (Entry, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry, ok := j.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	return *entry, true
+}
+
+// Begin records the vend before it is run. It returns ErrDuplicateKey if the
+// key has been used, whatever the outcome was.
+func (j *Journal) Begin(key string, request Request) error {
+	if key == "" {
+		return ErrNoKey
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.entries[key]; ok {
+		return ErrDuplicateKey
+	}
+	return j.write(record{
+		Type:      RECORD_BEGIN,
+		Key:       key,
+		Time:      time.Now(),
+		Slot:      request.Slot,
+		Reference: request.Reference,
+	})
+}
+
+// End records the outcome of the vend.
+func (j *Journal) End(key string, result Result) error {
+	return j.end(RECORD_END, key, result.Outcome, result.Reason)
+}
+
+// Resolve records the outcome of an interrupted vend once it is known, e.g.
+// after someone has checked the machine.
+func (j *Journal) Resolve(key, outcome, reason string) error {
+	return j.end(RECORD_RESOLVE, key, outcome, reason)
+}
+
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+func (j *Journal) end(recordType, key, outcome, reason string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if _, ok := j.entries[key]; !ok {
+		return ErrNoSuchKey
+	}
+	return j.write(record{
+		Type:    recordType,
+		Key:     key,
+		Time:    time.Now(),
+		Outcome: outcome,
+		Reason:  reason,
+	})
+}
+
+// write appends the record and syncs it to disk before it is applied, the
+// lock is held.
+func (j *Journal) write(r record) error {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if err := j.file.Sync(); err != nil {
+		return err
+	}
+	j.apply(r)
+	return nil
+}
+
+func (j *Journal) apply(r record) {
+	switch r.Type {
+	case RECORD_BEGIN:
+		j.entries[r.Key] = &Entry{
+			Key:       r.Key,
+			Slot:      r.Slot,
+			Reference: r.Reference,
+			BeganAt:   r.Time,
+		}
+	case RECORD_END, RECORD_RESOLVE:
+		entry, ok := j.entries[r.Key]
+		if !ok {
+			return
+		}
+		entry.EndedAt = r.Time
+		entry.Outcome = r.Outcome
+		entry.Reason = r.Reason
+	}
+}
+
+// terminate ends the last line of file if a crash has left it unfinished, so
+// that the next record is not appended to it.
+func terminate(file *os.File) error {
+	info, err := file.Stat()
+	if err != nil || info.Size() == 0 {
+		return err
+	}
+	last := make([]byte, 1)
+	if _, err := file.ReadAt(last, info.Size()-1); err != nil {
+		return err
+	}
+	if last[0] == '\n' {
+		return nil
+	}
+	_, err = file.Write([]byte{'\n'})
+	return err
+}
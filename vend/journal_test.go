@@ -0,0 +1,132 @@
+package vend_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/caiguanhao/vending-processors/vend"
+)
+
+func openJournal(t *testing.T) (*vend.Journal, string) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "journal")
+	j, err := vend.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j, path
+}
+
+func TestJournal(t *testing.T) {
+	j, path := openJournal(t)
+	if err := j.Begin("", vend.Request{}); err != vend.ErrNoKey {
+		t.Errorf("Begin without key = %v", err)
+	}
+	if err := j.End("none", vend.Result{}); err != vend.ErrNoSuchKey {
+		t.Errorf("End of unknown key = %v", err)
+	}
+	if err := j.Begin("a", vend.Request{Slot: 1, Reference: "pay-a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.End("a", vend.Result{Outcome: vend.OUTCOME_DELIVERED}); err != nil {
+		t.Fatal(err)
+	}
+	if err := j.Begin("a", vend.Request{Slot: 1}); err != vend.ErrDuplicateKey {
+		t.Errorf("Begin of used key = %v", err)
+	}
+	if err := j.Begin("b", vend.Request{Slot: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Recover()) != 0 {
+		t.Error("a vend in progress should not be in doubt")
+	}
+	j.Close()
+
+	// the process crashed while b was running
+	j, err := vend.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if entry, ok := j.Get("a"); !ok || entry.InDoubt || entry.Outcome != vend.OUTCOME_DELIVERED || entry.Reference != "pay-a" {
+		t.Errorf("a = %+v", entry)
+	}
+	entries := j.Recover()
+	if len(entries) != 1 || entries[0].Key != "b" || !entries[0].InDoubt || entries[0].Slot != 2 {
+		t.Fatalf("Recover() = %+v", entries)
+	}
+	if err := j.Resolve("b", vend.OUTCOME_NOT_DELIVERED, "checked"); err != nil {
+		t.Fatal(err)
+	}
+	if len(j.Recover()) != 0 {
+		t.Error("a resolved vend should not be in doubt")
+	}
+	if entry, _ := j.Get("b"); entry.Outcome != vend.OUTCOME_NOT_DELIVERED || entry.Reason != "checked" {
+		t.Errorf("b = %+v", entry)
+	}
+}
+
+func TestJournalCorrupted(t *testing.T) {
+	j, path := openJournal(t)
+	if err := j.Begin("a", vend.Request{Slot: 1}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// the process crashed while writing a line
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write([]byte(`{"type":"end","key":"a","outc`))
+	file.Close()
+
+	j, err = vend.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if j.Corrupted() != 1 || len(j.Recover()) != 1 {
+		t.Errorf("corrupted = %d, recover = %+v", j.Corrupted(), j.Recover())
+	}
+	if err := j.Resolve("a", vend.OUTCOME_DELIVERED, ""); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	// the record after the partial line is read
+	j, err = vend.OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	if entry, _ := j.Get("a"); j.Corrupted() != 1 || entry.InDoubt || entry.Outcome != vend.OUTCOME_DELIVERED {
+		t.Errorf("corrupted = %d, a = %+v", j.Corrupted(), entry)
+	}
+}
+
+func TestVendJournal(t *testing.T) {
+	j, _ := openJournal(t)
+	defer j.Close()
+	m, _ := newZiman()
+	v := &vend.Vendor{Machine: m, Journal: j}
+	result, err := v.Vend(context.Background(), vend.Request{Key: "a", Slot: 1})
+	if err != nil || result.Outcome != vend.OUTCOME_DELIVERED {
+		t.Fatalf("result = %+v, err = %v", result, err)
+	}
+	if entry, _ := j.Get("a"); entry.Outcome != vend.OUTCOME_DELIVERED || entry.EndedAt.IsZero() {
+		t.Errorf("a = %+v", entry)
+	}
+	if _, err := v.Vend(context.Background(), vend.Request{Key: "a", Slot: 1}); err != vend.ErrDuplicateKey {
+		t.Errorf("second vend = %v", err)
+	}
+	if _, err := v.Vend(context.Background(), vend.Request{Slot: 1}); err != vend.ErrNoKey {
+		t.Errorf("vend without key = %v", err)
+	}
+}
@@ -0,0 +1,230 @@
+// Package vend runs a vend transaction on a vending.Machine and tells whether
+// the product was delivered, so that the payment service can decide whether
+// to refund.
+package vend
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	"github.com/caiguanhao/vending-processors/transport"
+	"github.com/caiguanhao/vending-processors/vending"
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+const (
+	// the product has been dispensed
+	OUTCOME_DELIVERED = "delivered"
+	// nothing has been dispensed, the payment can be refunded
+	OUTCOME_NOT_DELIVERED = "not_delivered"
+	// the command may or may not have run, e.g. the reply timed out
+	OUTCOME_UNKNOWN = "unknown"
+
+	STEP_PRECHECK = "precheck"
+	STEP_DISPENSE = "dispense"
+	STEP_VERIFY   = "verify"
+)
+
+type (
+	Request struct {
+		// idempotency key, required if Vendor has a Journal
+		Key  string `json:"key"`
+		Slot int    `json:"slot"`
+		// e.g. the payment ID, returned as is
+		Reference string `json:"reference"`
+	}
+
+	Result struct {
+		Key       string `json:"key"`
+		Slot      int    `json:"slot"`
+		Reference string `json:"reference"`
+		Outcome   string `json:"outcome"`
+		// true if the outcome is OUTCOME_NOT_DELIVERED
+		Refund bool `json:"refund"`
+		// true if the delivery is confirmed by a sensor, not only by the
+		// reply of the board
+		Verified   bool      `json:"verified"`
+		Reason     string    `json:"reason"`
+		Steps      []Step    `json:"steps"`
+		StartedAt  time.Time `json:"started_at"`
+		FinishedAt time.Time `json:"finished_at"`
+	}
+
+	Step struct {
+		Name string    `json:"name"`
+		Time time.Time `json:"time"`
+		// milliseconds
+		Duration int    `json:"duration"`
+		OK       bool   `json:"ok"`
+		Error    string `json:"error"`
+		Bytes    []byte `json:"bytes"`
+	}
+
+	// Vendor runs transactions on Machine. With a *vending.TCN that has a
+	// lifter, the delivery is verified with LifterCheckExistence, also when
+	// the lifter is interrupted during its cycle.
+	Vendor struct {
+		Machine vending.Machine
+		// optional, records each vend and refuses keys that have been used
+		Journal *Journal
+		// optional, called after each step
+		OnStep func(Step)
+	}
+)
+
+// notSent are errors returned before a command is written.
+var notSent = []error{
+	tcn.ErrNoSuchClient,
+	tcn.ErrProcessing,
+	tcn.ErrNoContent,
+	tcn.ErrQueueFull,
+	tcn.ErrQueueTimeout,
+	tcn.ErrSlotDisabled,
+	ziman.ErrNoSuchClient,
+	ziman.ErrProcessing,
+	ziman.ErrNoContent,
+	ziman.ErrQueueTimeout,
+	ziman.ErrNoFrame,
+	ziman.ErrSlotDisabled,
+	vending.ErrNoSuchSlot,
+	vending.ErrNotSupported,
+	transport.ErrNotConnected,
+	transport.ErrClosed,
+}
+
+// Vend checks the machine, dispenses the slot and verifies the delivery. An
+// error means the vend has not been run, or its outcome could not be
+// recorded in the journal.
+func (v *Vendor) Vend(ctx context.Context, request Request) (Result, error) {
+	if v.Journal != nil {
+		if err := v.Journal.Begin(request.Key, request); err != nil {
+			return Result{}, err
+		}
+	}
+	result := v.vend(ctx, request)
+	if v.Journal != nil {
+		if err := v.Journal.End(request.Key, result); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+func (v *Vendor) vend(ctx context.Context, request Request) (result Result) {
+	result = Result{
+		Key:       request.Key,
+		Slot:      request.Slot,
+		Reference: request.Reference,
+		Steps:     []Step{},
+		StartedAt: time.Now(),
+	}
+	defer func() {
+		result.Refund = result.Outcome == OUTCOME_NOT_DELIVERED
+		result.FinishedAt = time.Now()
+	}()
+
+	start := time.Now()
+	health, err := v.Machine.Health(ctx)
+	if err == nil && len(health.Problems) > 0 {
+		err = problems(health.Problems)
+	}
+	v.step(&result, STEP_PRECHECK, start, nil, err)
+	if err != nil {
+		result.Outcome = OUTCOME_NOT_DELIVERED
+		result.Reason = "precheck failed: " + err.Error()
+		return
+	}
+
+	start = time.Now()
+	dispensed, err := v.Machine.Dispense(ctx, request.Slot)
+	failed := err == nil && !dispensed.Success
+	if failed {
+		err = problems{"board replied with failure"}
+		if dispensed.Reason != "" {
+			err = problems{dispensed.Reason}
+		}
+	}
+	v.step(&result, STEP_DISPENSE, start, dispensed.Bytes, err)
+	switch {
+	case dispensed.Interrupted:
+		// only the sensor can tell whether the product has been dispensed
+		result.Outcome = OUTCOME_UNKNOWN
+		result.Reason = "interrupted: " + err.Error()
+	case failed:
+		result.Outcome = OUTCOME_NOT_DELIVERED
+		result.Reason = err.Error()
+		return
+	case err != nil:
+		result.Outcome = OUTCOME_UNKNOWN
+		if isNotSent(err) {
+			result.Outcome = OUTCOME_NOT_DELIVERED
+		}
+		result.Reason = err.Error()
+		return
+	default:
+		result.Outcome = OUTCOME_DELIVERED
+	}
+
+	m, ok := v.Machine.(*vending.TCN)
+	if !ok || !m.Lifter {
+		// no sensor, the reply of the board is all we know
+		return
+	}
+	start = time.Now()
+	existence, err := m.Machine.LifterCheckExistence(ctx)
+	if err == nil && existence.Exists == nil {
+		err = problems{"unknown existence"}
+	}
+	v.step(&result, STEP_VERIFY, start, existence.Bytes, err)
+	switch {
+	case err != nil && dispensed.Interrupted:
+		result.Reason += "; not verified: " + err.Error()
+	case err != nil:
+		// the lifter has reported success, but it is not confirmed
+		result.Reason = "not verified: " + err.Error()
+	case *existence.Exists:
+		result.Outcome = OUTCOME_DELIVERED
+		result.Verified = true
+	case dispensed.Interrupted:
+		result.Reason += "; no product is in the tray"
+	default:
+		result.Outcome = OUTCOME_UNKNOWN
+		result.Reason = "lifter reported success but no product is in the tray"
+	}
+	return
+}
+
+func (v *Vendor) step(result *Result, name string, start time.Time, bytes []byte, err error) {
+	step := Step{
+		Name:     name,
+		Time:     start,
+		Duration: int(time.Since(start) / time.Millisecond),
+		OK:       err == nil,
+		Bytes:    bytes,
+	}
+	if err != nil {
+		step.Error = err.Error()
+	}
+	result.Steps = append(result.Steps, step)
+	if v.OnStep != nil {
+		v.OnStep(step)
+	}
+}
+
+func isNotSent(err error) bool {
+	for _, e := range notSent {
+		if err == e {
+			return true
+		}
+	}
+	return false
+}
+
+// problems is the error of an unhealthy machine or a failed dispense.
+type problems []string
+
+func (p problems) Error() string {
+	return strings.Join(p, "; ")
+}
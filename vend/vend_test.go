@@ -0,0 +1,145 @@
+package vend_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	tcnsim "github.com/caiguanhao/vending-processors/tcn/sim"
+	"github.com/caiguanhao/vending-processors/transport"
+	"github.com/caiguanhao/vending-processors/vend"
+	"github.com/caiguanhao/vending-processors/vending"
+	"github.com/caiguanhao/vending-processors/ziman"
+	zimansim "github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func newTCN(lifter bool) (*vending.TCN, *tcnsim.Board) {
+	board := tcnsim.NewBoard()
+	board.RotateDuration = 20 * time.Millisecond
+	board.ShipDuration = 50 * time.Millisecond
+	return &vending.TCN{Machine: &tcn.Machine{Client: board, HideLogs: true}, Lifter: lifter}, board
+}
+
+func newZiman() (*vending.Ziman, *zimansim.Board) {
+	board := zimansim.NewBoard()
+	board.MotorDuration = 20 * time.Millisecond
+	return &vending.Ziman{Machine: &ziman.Machine{Client: board}}, board
+}
+
+func TestVendTCN(t *testing.T) {
+	m, board := newTCN(false)
+	board.Jam(2, true)
+	v := &vend.Vendor{Machine: m}
+	tests := []struct {
+		slot    int
+		outcome string
+	}{
+		{1, vend.OUTCOME_DELIVERED},
+		{2, vend.OUTCOME_NOT_DELIVERED},
+	}
+	for _, test := range tests {
+		result, err := v.Vend(context.Background(), vend.Request{Slot: test.slot, Reference: "pay"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Outcome != test.outcome || result.Refund != (test.outcome == vend.OUTCOME_NOT_DELIVERED) ||
+			result.Verified || result.Reference != "pay" || len(result.Steps) != 2 {
+			t.Errorf("slot %d: result = %+v", test.slot, result)
+		}
+	}
+}
+
+func TestVendLifter(t *testing.T) {
+	m, board := newTCN(true)
+	var steps []string
+	v := &vend.Vendor{Machine: m, OnStep: func(step vend.Step) {
+		steps = append(steps, step.Name)
+	}}
+	result, err := v.Vend(context.Background(), vend.Request{Slot: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != vend.OUTCOME_DELIVERED || !result.Verified || len(steps) != 3 || steps[2] != vend.STEP_VERIFY {
+		t.Errorf("result = %+v, steps = %v", result, steps)
+	}
+
+	// the product has not been collected
+	board.SetLifterError(tcn.LIFTER_ERROR_GOODS_IN_HOPPER)
+	result, err = v.Vend(context.Background(), vend.Request{Slot: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != vend.OUTCOME_NOT_DELIVERED || !result.Refund || len(result.Steps) != 1 || result.Steps[0].Name != vend.STEP_PRECHECK {
+		t.Errorf("precheck: result = %+v", result)
+	}
+}
+
+// unplugged fails every write after the first n, like a board that is
+// unplugged in the middle of a vend.
+type unplugged struct {
+	*tcnsim.Board
+	mu sync.Mutex
+	n  int
+}
+
+func (u *unplugged) Write(p []byte) (int, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.n == 0 {
+		return 0, transport.ErrNotConnected
+	}
+	u.n--
+	return u.Board.Write(p)
+}
+
+func TestVendLifterInterrupted(t *testing.T) {
+	// the lifter fails after it has started
+	m, board := newTCN(true)
+	board.Jam(3, true)
+	v := &vend.Vendor{Machine: m}
+	result, err := v.Vend(context.Background(), vend.Request{Slot: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != vend.OUTCOME_UNKNOWN || result.Refund || result.Verified || len(result.Steps) != 3 {
+		t.Errorf("lifter error: result = %+v", result)
+	}
+
+	// the status poll fails after the ship command has been accepted,
+	// with an error that is returned before anything is written
+	m, board = newTCN(true)
+	// precheck: status and lifter status, dispense: lifter status and ship
+	m.Machine.Client = &unplugged{Board: board, n: 4}
+	v.Machine = m
+	result, err = v.Vend(context.Background(), vend.Request{Slot: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Outcome != vend.OUTCOME_UNKNOWN || result.Refund || len(result.Steps) != 3 ||
+		result.Steps[1].Error != transport.ErrNotConnected.Error() {
+		t.Errorf("status poll: result = %+v", result)
+	}
+}
+
+func TestVendZiman(t *testing.T) {
+	m, board := newZiman()
+	v := &vend.Vendor{Machine: m}
+	result, err := v.Vend(context.Background(), vend.Request{Slot: 1})
+	if err != nil || result.Outcome != vend.OUTCOME_DELIVERED || result.Verified {
+		t.Errorf("result = %+v, err = %v", result, err)
+	}
+	if result, _ := v.Vend(context.Background(), vend.Request{Slot: 0}); result.Outcome != vend.OUTCOME_NOT_DELIVERED {
+		t.Errorf("no such slot: result = %+v", result)
+	}
+
+	// the motor runs longer than the vend may take
+	board.MotorDuration = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer cancel()
+	result, err = v.Vend(ctx, vend.Request{Slot: 2})
+	if err != nil || result.Outcome != vend.OUTCOME_UNKNOWN || result.Refund {
+		t.Errorf("timeout: result = %+v, err = %v", result, err)
+	}
+}
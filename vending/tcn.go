@@ -0,0 +1,118 @@
+package vending
+
+import (
+	"context"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+)
+
+// TCN is the Machine of a TCN board.
+type TCN struct {
+	Machine *tcn.Machine
+	// ship with the lifter instead of rotating the slot
+	Lifter bool
+	// optional, the logical slot is the TCN number if it is empty
+	Slots Slots
+}
+
+var _ Machine = (*TCN)(nil)
+
+func (t *TCN) Dispense(ctx context.Context, slot int) (Dispensed, error) {
+	number, err := t.number(slot)
+	if err != nil {
+		return Dispensed{}, err
+	}
+	dispensed := Dispensed{Slot: slot}
+	if t.Lifter {
+		var started bool
+		status, err := t.Machine.LifterShipWithProgress(ctx, number, func(progress tcn.LifterProgress) {
+			if progress.Stage == tcn.LIFTER_STAGE_STARTED {
+				started = true
+			}
+		})
+		// only a lifter that is not ready is known to have shipped nothing
+		dispensed.Interrupted = started && (err != nil || !status.OK)
+		if err != nil {
+			return dispensed, err
+		}
+		dispensed.Bytes = status.Bytes
+		dispensed.Success = status.OK
+		if status.Error != tcn.LIFTER_ERROR_NONE {
+			dispensed.Reason = status.Error.Message()
+		}
+		return dispensed, nil
+	}
+	dispensed.Success, err = t.Machine.Rotate(ctx, number)
+	return dispensed, err
+}
+
+func (t *TCN) Status(ctx context.Context) (Status, error) {
+	status, err := t.Machine.Status(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Time:        status.Time,
+		Temperature: status.ActualTemperature,
+	}, nil
+}
+
+func (t *TCN) Temperature(ctx context.Context, mode string, target int) error {
+	switch mode {
+	case TEMPERATURE_OFF:
+		if err := t.Machine.TurnOffRefrigerator(ctx); err != nil {
+			return err
+		}
+		return t.Machine.TurnOffHeater(ctx)
+	case TEMPERATURE_COOL:
+		if err := t.Machine.TurnOffHeater(ctx); err != nil {
+			return err
+		}
+		return t.Machine.TurnOnRefrigerator(ctx, target)
+	case TEMPERATURE_HEAT:
+		if err := t.Machine.TurnOffRefrigerator(ctx); err != nil {
+			return err
+		}
+		return t.Machine.TurnOnHeater(ctx)
+	}
+	return ErrNotSupported
+}
+
+func (t *TCN) Lights(ctx context.Context, on bool) error {
+	if on {
+		return t.Machine.TurnOnLights(ctx)
+	}
+	return t.Machine.TurnOffLights(ctx)
+}
+
+// Health checks the board, and the lifter if there is one.
+func (t *TCN) Health(ctx context.Context) (Health, error) {
+	start := time.Now()
+	_, err := t.Machine.Status(ctx)
+	if err == tcn.ErrNoSuchClient {
+		return Health{}, err
+	}
+	health := newHealth(start, err)
+	if err != nil || !t.Lifter {
+		return health, nil
+	}
+	status, err := t.Machine.LifterStatus(ctx)
+	if err != nil {
+		health.Problems = append(health.Problems, "lifter: "+err.Error())
+	} else if status.Error != tcn.LIFTER_ERROR_NONE {
+		health.Problems = append(health.Problems, "lifter: "+status.Error.Message())
+	}
+	return health, nil
+}
+
+func (t *TCN) number(slot int) (int, error) {
+	address, ok := t.Slots.Lookup(slot)
+	if !ok {
+		return 0, ErrNoSuchSlot
+	}
+	if len(t.Slots) == 0 {
+		return slot, nil
+	}
+	return address.Number, nil
+}
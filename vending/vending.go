@@ -0,0 +1,99 @@
+// Package vending is one API for the TCN and ziman boards, so that the app
+// does not need a code path for each of them.
+package vending
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	TEMPERATURE_OFF  = "off"
+	TEMPERATURE_COOL = "cool"
+	TEMPERATURE_HEAT = "heat"
+)
+
+var (
+	// the board has no such feature
+	ErrNotSupported = errors.New("not supported")
+	ErrNoSuchSlot   = errors.New("no such slot")
+)
+
+type (
+	Machine interface {
+		// Dispense dispenses the product in the logical slot.
+		Dispense(ctx context.Context, slot int) (Dispensed, error)
+		Status(ctx context.Context) (Status, error)
+		// Temperature sets the mode (TEMPERATURE_*) and the target in
+		// Celsius, which is ignored when it is turned off.
+		Temperature(ctx context.Context, mode string, target int) error
+		Lights(ctx context.Context, on bool) error
+		// Health checks that the board replies, problems of the board are
+		// reported in Health instead of as an error.
+		Health(ctx context.Context) (Health, error)
+	}
+
+	// Slot is the address of a logical slot on a board, Number for TCN and
+	// Row and Column for ziman.
+	Slot struct {
+		Number int `json:"number"`
+		Row    int `json:"row"`
+		Column int `json:"column"`
+	}
+
+	// Slots maps logical slots to board addresses.
+	Slots map[int]Slot
+
+	Dispensed struct {
+		Slot    int    `json:"slot"`
+		Success bool   `json:"success"`
+		Bytes   []byte `json:"bytes"`
+		// why it was not successful, if the board tells
+		Reason string `json:"reason"`
+		// true if dispensing has started and then failed or could not be
+		// followed, e.g. the lifter reported an error or its status could
+		// not be read during its cycle, so the product may have been
+		// dispensed whatever the error is
+		Interrupted bool `json:"interrupted"`
+	}
+
+	Status struct {
+		Time        time.Time `json:"time"`
+		Temperature int       `json:"temperature"`
+		// 0 if the board does not report it
+		TargetTemperature int  `json:"target_temperature"`
+		Refrigerating     bool `json:"refrigerating"`
+	}
+
+	Health struct {
+		Time   time.Time `json:"time"`
+		Online bool      `json:"online"`
+		// milliseconds the board took to reply
+		Latency  int      `json:"latency"`
+		Problems []string `json:"problems"`
+	}
+)
+
+// Lookup returns the address of the slot, ok is false if slots is not empty
+// and has no such slot.
+func (slots Slots) Lookup(slot int) (address Slot, ok bool) {
+	if len(slots) == 0 {
+		return Slot{}, true
+	}
+	address, ok = slots[slot]
+	return
+}
+
+func newHealth(start time.Time, err error) Health {
+	health := Health{
+		Time:     start,
+		Online:   err == nil,
+		Latency:  int(time.Since(start) / time.Millisecond),
+		Problems: []string{},
+	}
+	if err != nil {
+		health.Problems = append(health.Problems, err.Error())
+	}
+	return health
+}
@@ -0,0 +1,116 @@
+package vending_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/tcn"
+	tcnsim "github.com/caiguanhao/vending-processors/tcn/sim"
+	"github.com/caiguanhao/vending-processors/vending"
+	"github.com/caiguanhao/vending-processors/ziman"
+	zimansim "github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func newTCN() (*vending.TCN, *tcnsim.Board) {
+	board := tcnsim.NewBoard()
+	board.RotateDuration = 20 * time.Millisecond
+	board.ShipDuration = 50 * time.Millisecond
+	return &vending.TCN{Machine: &tcn.Machine{Client: board, HideLogs: true}}, board
+}
+
+func newZiman() (*vending.Ziman, *zimansim.Board) {
+	board := zimansim.NewBoard()
+	board.MotorDuration = 20 * time.Millisecond
+	return &vending.Ziman{Machine: &ziman.Machine{Client: board}}, board
+}
+
+func TestDispense(t *testing.T) {
+	ctx := context.Background()
+	tcnMachine, tcnBoard := newTCN()
+	tcnBoard.Jam(12, true)
+	tcnMachine.Slots = vending.Slots{1: {Number: 11}, 2: {Number: 12}}
+	zimanMachine, zimanBoard := newZiman()
+	zimanBoard.Fail(2, 2, true)
+	zimanMachine.Columns = 5
+	tests := []struct {
+		machine vending.Machine
+		slot    int
+		success bool
+		err     error
+	}{
+		{tcnMachine, 1, true, nil},
+		{tcnMachine, 2, false, nil},
+		{tcnMachine, 3, false, vending.ErrNoSuchSlot},
+		{zimanMachine, 1, true, nil},
+		{zimanMachine, 7, false, nil}, // row 2 column 2
+		{zimanMachine, 0, false, vending.ErrNoSuchSlot},
+	}
+	for i, test := range tests {
+		dispensed, err := test.machine.Dispense(ctx, test.slot)
+		if err != test.err || dispensed.Success != test.success {
+			t.Errorf("#%d: dispensed = %+v, err = %v", i, dispensed, err)
+		}
+	}
+	if stock := tcnBoard.Stock(11); stock != tcnBoard.Capacity-1 {
+		t.Errorf("stock of 11 = %d", stock)
+	}
+}
+
+func TestDispenseLifter(t *testing.T) {
+	m, board := newTCN()
+	m.Lifter = true
+	board.SetStock(5, 0)
+	dispensed, err := m.Dispense(context.Background(), 5)
+	if err != nil || dispensed.Success || dispensed.Reason != tcn.LIFTER_ERROR_NO_GOODS_AT_PICKUP.Message() {
+		t.Errorf("dispensed = %+v, err = %v", dispensed, err)
+	}
+	health, err := m.Health(context.Background())
+	if err != nil || !health.Online || len(health.Problems) != 1 {
+		t.Errorf("health = %+v, err = %v", health, err)
+	}
+}
+
+func TestStatusAndControls(t *testing.T) {
+	ctx := context.Background()
+	tcnMachine, tcnBoard := newTCN()
+	tcnBoard.SetTemperature(9)
+	if status, err := tcnMachine.Status(ctx); err != nil || status.Temperature != 9 {
+		t.Errorf("tcn status = %+v, err = %v", status, err)
+	}
+	if err := tcnMachine.Temperature(ctx, vending.TEMPERATURE_COOL, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := tcnMachine.Lights(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if state := tcnBoard.State(); !state.Cooling || state.Heater || state.TargetTemperature != 4 || !state.Lights {
+		t.Errorf("state = %+v", state)
+	}
+	if err := tcnMachine.Temperature(ctx, "warm", 0); err != vending.ErrNotSupported {
+		t.Errorf("err = %v, want ErrNotSupported", err)
+	}
+
+	zimanMachine, zimanBoard := newZiman()
+	zimanBoard.SetTemperature(5, 7, true)
+	if status, err := zimanMachine.Status(ctx); err != nil || status.Temperature != 7 || status.TargetTemperature != 5 || !status.Refrigerating {
+		t.Errorf("ziman status = %+v, err = %v", status, err)
+	}
+	if err := zimanMachine.Lights(ctx, true); err != vending.ErrNotSupported {
+		t.Errorf("err = %v, want ErrNotSupported", err)
+	}
+}
+
+func TestHealthOffline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	m, board := newZiman()
+	board.Delay = time.Second
+	health, err := m.Health(ctx)
+	if err != nil || health.Online || len(health.Problems) != 1 {
+		t.Errorf("health = %+v, err = %v", health, err)
+	}
+	if _, err := (&vending.TCN{Machine: &tcn.Machine{}}).Health(ctx); err != tcn.ErrNoSuchClient {
+		t.Errorf("err = %v, want ErrNoSuchClient", err)
+	}
+}
@@ -0,0 +1,94 @@
+package vending
+
+import (
+	"context"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+// Ziman is the Machine of a ziman locker or spiral board.
+type Ziman struct {
+	Machine *ziman.Machine
+	// unlock the cell instead of rotating it, for lockers
+	Lock bool
+	// used when Slots is empty, logical slots are numbered row by row, 1 is
+	// row 1 column 1, defaults to 10
+	Columns int
+	// optional
+	Slots Slots
+}
+
+var _ Machine = (*Ziman)(nil)
+
+func (z *Ziman) Dispense(ctx context.Context, slot int) (Dispensed, error) {
+	row, column, err := z.cell(slot)
+	if err != nil {
+		return Dispensed{}, err
+	}
+	var reply ziman.Reply
+	if z.Lock {
+		reply, err = z.Machine.Unlock(ctx, row, column)
+	} else {
+		reply, err = z.Machine.Rotate(ctx, row, column)
+	}
+	if err != nil {
+		return Dispensed{Slot: slot}, err
+	}
+	return Dispensed{
+		Slot:    slot,
+		Success: reply.Success,
+		Bytes:   reply.Bytes,
+	}, nil
+}
+
+func (z *Ziman) Status(ctx context.Context) (Status, error) {
+	status, err := z.Machine.Status(ctx)
+	if err != nil {
+		return Status{}, err
+	}
+	return Status{
+		Time:              status.Time,
+		Temperature:       status.ActualTemperature,
+		TargetTemperature: status.ExpectedTemperature,
+		Refrigerating:     status.RefrigeratorOperating,
+	}, nil
+}
+
+// Temperature is not supported, the temperature is set on the board.
+func (z *Ziman) Temperature(ctx context.Context, mode string, target int) error {
+	return ErrNotSupported
+}
+
+func (z *Ziman) Lights(ctx context.Context, on bool) error {
+	return ErrNotSupported
+}
+
+func (z *Ziman) Health(ctx context.Context) (Health, error) {
+	start := time.Now()
+	_, err := z.Machine.Status(ctx)
+	if err == ziman.ErrNoSuchClient {
+		return Health{}, err
+	}
+	return newHealth(start, err), nil
+}
+
+func (z *Ziman) cell(slot int) (row, column int, err error) {
+	address, ok := z.Slots.Lookup(slot)
+	if !ok {
+		err = ErrNoSuchSlot
+		return
+	}
+	if len(z.Slots) > 0 {
+		return address.Row, address.Column, nil
+	}
+	if slot < 1 {
+		err = ErrNoSuchSlot
+		return
+	}
+	columns := z.Columns
+	if columns == 0 {
+		columns = 10
+	}
+	return (slot-1)/columns + 1, (slot-1)%columns + 1, nil
+}
@@ -0,0 +1,171 @@
+package ziman
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"sync"
+)
+
+const (
+	// 0xA8, size, function, frame, checksum and 0xFE
+	MIN_FRAME_SIZE = 6
+
+	DEFAULT_MAX_BUFFER_SIZE = 64
+)
+
+type (
+	Frame struct {
+		Function byte
+		Bytes    []byte
+	}
+
+	// Decoder turns a stream of bytes read from a ziman board into frames.
+	// It never keeps more than MaxBufferSize bytes: a frame with a bad size,
+	// checksum or terminator is skipped one byte at a time until the next
+	// 0xA8 that starts a valid frame. Process uses it for every read.
+	Decoder struct {
+		// frames declaring a size larger than this are dropped, defaults to
+		// DEFAULT_MAX_BUFFER_SIZE
+		MaxBufferSize int
+
+		r    io.Reader
+		read []byte
+
+		mu     sync.Mutex
+		buf    []byte
+		frames []Frame
+		stats  Stats
+	}
+
+	Stats struct {
+		Frames         int `json:"frames"`
+		DroppedBytes   int `json:"dropped_bytes"`
+		SizeErrors     int `json:"size_errors"`
+		ChecksumErrors int `json:"checksum_errors"`
+	}
+)
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{
+		MaxBufferSize: DEFAULT_MAX_BUFFER_SIZE,
+		r:             r,
+		read:          make([]byte, 256),
+	}
+}
+
+func (f Frame) Dispatch(multiChannels ...*sync.Map) {
+	dispatch(f.Bytes, multiChannels...)
+}
+
+func (d *Decoder) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.buf = append(d.buf, p...)
+	d.decode()
+	return len(p), nil
+}
+
+// Buffered returns the number of bytes waiting for the rest of a frame.
+func (d *Decoder) Buffered() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.buf)
+}
+
+func (d *Decoder) Stats() Stats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.stats
+}
+
+// Decode returns the next frame, reading from the underlying reader if no
+// decoded frame is pending. It returns io.EOF if there is no reader.
+func (d *Decoder) Decode() (Frame, error) {
+	for {
+		d.mu.Lock()
+		if len(d.frames) > 0 {
+			frame := d.frames[0]
+			d.frames = d.frames[1:]
+			d.mu.Unlock()
+			return frame, nil
+		}
+		d.mu.Unlock()
+		if d.r == nil {
+			return Frame{}, io.EOF
+		}
+		n, err := d.r.Read(d.read)
+		if n > 0 {
+			d.Write(d.read[:n])
+		}
+		if err != nil && d.pending() == 0 {
+			return Frame{}, err
+		}
+	}
+}
+
+// Run decodes frames until the reader fails and sends each of them to the
+// matching channel, like Process does.
+func (d *Decoder) Run(multiChannels ...*sync.Map) error {
+	for {
+		frame, err := d.Decode()
+		if err != nil {
+			return err
+		}
+		log.Println("received", frame.Bytes)
+		frame.Dispatch(multiChannels...)
+	}
+}
+
+func (d *Decoder) pending() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.frames)
+}
+
+func (d *Decoder) decode() {
+	max := d.MaxBufferSize
+	if max < MIN_FRAME_SIZE {
+		max = DEFAULT_MAX_BUFFER_SIZE
+	}
+	for len(d.buf) > 0 {
+		index := bytes.IndexByte(d.buf, 0xa8)
+		if index == -1 {
+			d.drop(len(d.buf))
+			return
+		}
+		d.drop(index)
+		if len(d.buf) < 2 {
+			return
+		}
+		size := int(d.buf[1])
+		if size < MIN_FRAME_SIZE || size > max {
+			d.stats.SizeErrors++
+			d.drop(1)
+			continue
+		}
+		if len(d.buf) < size {
+			return
+		}
+		data := d.buf[:size]
+		if !validData(data) {
+			d.stats.ChecksumErrors++
+			d.drop(1)
+			continue
+		}
+		d.stats.Frames++
+		d.frames = append(d.frames, Frame{
+			Function: data[2],
+			Bytes:    append([]byte(nil), data...),
+		})
+		d.buf = d.buf[size:]
+	}
+}
+
+func (d *Decoder) drop(n int) {
+	if n == 0 {
+		return
+	}
+	d.stats.DroppedBytes += n
+	d.buf = d.buf[n:]
+}
@@ -0,0 +1,105 @@
+package ziman
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"testing"
+)
+
+func TestDecoderResync(t *testing.T) {
+	rotate := FrameBytes(FUNC_ROTATE, 7, []byte{1, 2, 10, 1})
+	status := FrameBytes(FUNC_STATUS, 3, []byte{5, 8, 1})
+	corrupted := append([]byte{}, rotate...)
+	corrupted[len(corrupted)-2]++
+	d := NewDecoder(nil)
+	d.Write([]byte{0x01, 0x02})
+	d.Write(corrupted)
+	d.Write(rotate[:4])
+	if d.Buffered() != 4 {
+		t.Errorf("buffered = %d, want 4", d.Buffered())
+	}
+	d.Write(rotate[4:])
+	d.Write(status)
+	for _, want := range [][]byte{rotate, status} {
+		frame, err := d.Decode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame.Bytes, want) || frame.Function != want[2] {
+			t.Errorf("frame = % X, want % X", frame.Bytes, want)
+		}
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+	stats := d.Stats()
+	if stats.Frames != 2 || stats.ChecksumErrors != 1 {
+		t.Errorf("stats = %+v, want 2 frames and 1 checksum error", stats)
+	}
+}
+
+func TestDecoderBoundedBuffer(t *testing.T) {
+	d := NewDecoder(nil)
+	d.MaxBufferSize = 16
+	// declares a size larger than the buffer
+	d.Write([]byte{0xa8, 0xff, 0x05, 0x00})
+	if d.Buffered() != 0 {
+		t.Errorf("buffered = %d, want 0", d.Buffered())
+	}
+	if stats := d.Stats(); stats.SizeErrors != 1 || stats.DroppedBytes != 4 {
+		t.Errorf("stats = %+v, want 1 size error and 4 dropped bytes", stats)
+	}
+}
+
+func TestDecoderReader(t *testing.T) {
+	check := FrameBytes(FUNC_CHECK, 1, []byte{1, 1})
+	d := NewDecoder(bytes.NewReader(append([]byte{0x00}, check...)))
+	frame, err := d.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(frame.Bytes, check) {
+		t.Errorf("frame = % X, want % X", frame.Bytes, check)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Errorf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestProcessDropped(t *testing.T) {
+	channels := &sync.Map{}
+	status := make(chan []byte, 1)
+	channels.Store(KEY_STATUS, status)
+	reply := FrameBytes(FUNC_STATUS, 1, []byte{5, 8, 1})
+	// the second reply has nobody waiting for it
+	Process(append(append([]byte{}, reply...), reply...), channels)
+	if dropped := Dropped(channels); dropped != 1 {
+		t.Errorf("dropped = %d, want 1", dropped)
+	}
+	if dropped := Dropped(&sync.Map{}); dropped != 0 {
+		t.Errorf("dropped of another client = %d, want 0", dropped)
+	}
+	if got := <-status; !bytes.Equal(got, reply) {
+		t.Errorf("status = % X, want % X", got, reply)
+	}
+}
+
+func TestProcessBounded(t *testing.T) {
+	channels := &sync.Map{}
+	status := make(chan []byte, 1)
+	channels.Store(KEY_STATUS, status)
+	reply := FrameBytes(FUNC_STATUS, 1, []byte{5, 8, 1})
+	// garbage, a frame declaring a huge size, then a reply split in two
+	data := append(bytes.Repeat([]byte{0x01}, 4096), 0xa8, 0xff, 0x00)
+	rest := Process(append(data, reply[:3]...), channels)
+	if !bytes.Equal(rest, reply[:3]) {
+		t.Fatalf("rest = % X, want % X", rest, reply[:3])
+	}
+	if rest := Process(append(rest, reply[3:]...), channels); len(rest) != 0 {
+		t.Errorf("rest = % X, want nothing", rest)
+	}
+	if got := <-status; !bytes.Equal(got, reply) {
+		t.Errorf("status = % X, want % X", got, reply)
+	}
+}
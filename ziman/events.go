@@ -0,0 +1,145 @@
+package ziman
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	// reply of a command that has timed out, only known if the client has
+	// a Sequence
+	EVENT_LATE_REPLY = "late_reply"
+	// frame nobody is waiting for, e.g. a report of the board
+	EVENT_UNMATCHED = "unmatched"
+	// frame with unknown function code or size
+	EVENT_UNKNOWN = "unknown"
+
+	// number of events kept for Since
+	EVENTS_HISTORY = 100
+)
+
+type (
+	// Event is a valid frame that is not the reply of a running command.
+	Event struct {
+		// 1 for the first event of the client
+		Sequence int
+		Time     time.Time
+		Type     string
+		Function byte
+		Bytes    []byte
+	}
+
+	// Events sends the events of a client to subscribers and keeps the
+	// latest EVENTS_HISTORY of them.
+	Events struct {
+		mu          sync.Mutex
+		last        int
+		history     []Event
+		subscribers map[chan Event]struct{}
+	}
+)
+
+// eventsKey is where the channels of a client keep its Events, so that they
+// go with the client.
+type eventsKey struct{}
+
+// EventsOf returns the Events of the client with the channels, it is created
+// if there is none.
+func EventsOf(channels *sync.Map) *Events {
+	e, _ := channels.LoadOrStore(eventsKey{}, &Events{})
+	return e.(*Events)
+}
+
+// Attach makes e the Events of the client with the channels, e.g. to keep
+// the events of a client_id across reconnects, which come with new channels.
+// The events kept for the channels before are moved to e.
+func (e *Events) Attach(channels *sync.Map) {
+	current, loaded := channels.LoadOrStore(eventsKey{}, e)
+	if !loaded || current == e {
+		return
+	}
+	channels.Store(eventsKey{}, e)
+	previous := current.(*Events)
+	previous.mu.Lock()
+	history := previous.history
+	previous.mu.Unlock()
+	for _, event := range history {
+		e.add(event)
+	}
+}
+
+// Reply parses the frame of a rotate, unlock or check event.
+func (e Event) Reply() Reply {
+	return ParseReply(e.Bytes)
+}
+
+// Subscribe returns a channel of events and a function to stop receiving
+// them. Events are dropped if the channel is full.
+func (e *Events) Subscribe(buffer int) (<-chan Event, func()) {
+	events := make(chan Event, buffer)
+	e.mu.Lock()
+	if e.subscribers == nil {
+		e.subscribers = map[chan Event]struct{}{}
+	}
+	e.subscribers[events] = struct{}{}
+	e.mu.Unlock()
+	var once sync.Once
+	return events, func() {
+		once.Do(func() {
+			e.mu.Lock()
+			delete(e.subscribers, events)
+			e.mu.Unlock()
+			close(events)
+		})
+	}
+}
+
+// Since returns the kept events with Sequence greater than since and the
+// Sequence of the last event, which is the since of the next call.
+func (e *Events) Since(since int) (events []Event, last int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, event := range e.history {
+		if event.Sequence > since {
+			events = append(events, event)
+		}
+	}
+	return events, e.last
+}
+
+func (e *Events) publish(eventType string, data []byte) {
+	e.add(Event{
+		Time:     time.Now(),
+		Type:     eventType,
+		Function: data[2],
+		Bytes:    data,
+	})
+}
+
+// add numbers the event and sends it to subscribers.
+func (e *Events) add(event Event) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.last++
+	event.Sequence = e.last
+	e.history = append(e.history, event)
+	if len(e.history) > EVENTS_HISTORY {
+		e.history = e.history[len(e.history)-EVENTS_HISTORY:]
+	}
+	for events := range e.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// publish sends data that is not the reply of a running command to the
+// Events of the client.
+func publish(channels *sync.Map, eventType string, data []byte) {
+	if eventType == EVENT_LATE_REPLY {
+		log.Println("late reply", data)
+	}
+	EventsOf(channels).publish(eventType, data)
+}
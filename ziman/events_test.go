@@ -0,0 +1,90 @@
+package ziman_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+func TestEventsUnmatched(t *testing.T) {
+	channels := &sync.Map{}
+	events := ziman.EventsOf(channels)
+	subscription, stop := events.Subscribe(10)
+	defer stop()
+	report := ziman.FrameBytes(ziman.FUNC_ROTATE, 9, []byte{1, 2, 10, 1})
+	unknown := ziman.FrameBytes(0x30, 1, []byte{1})
+	ziman.Process(append(append([]byte{}, report...), unknown...), channels)
+	for _, want := range []string{ziman.EVENT_UNMATCHED, ziman.EVENT_UNKNOWN} {
+		if event := <-subscription; event.Type != want {
+			t.Errorf("event = %+v, want %s", event, want)
+		}
+	}
+	list, last := events.Since(1)
+	if len(list) != 1 || last != 2 || list[0].Function != 0x30 {
+		t.Errorf("since 1 = %+v, last = %d", list, last)
+	}
+	if reply := (ziman.Event{Bytes: report}).Reply(); reply.Row != 1 || reply.Column != 2 || !reply.Success {
+		t.Errorf("reply = %+v", reply)
+	}
+}
+
+func TestEventsAttach(t *testing.T) {
+	channels := &sync.Map{}
+	report := ziman.FrameBytes(ziman.FUNC_ROTATE, 9, []byte{1, 2, 10, 1})
+	ziman.Process(append([]byte{}, report...), channels)
+	events := &ziman.Events{}
+	events.Attach(channels)
+	if ziman.EventsOf(channels) != events {
+		t.Error("events are not attached")
+	}
+	ziman.Process(append([]byte{}, report...), channels)
+	// the event before Attach is moved
+	if list, last := events.Since(0); len(list) != 2 || last != 2 {
+		t.Errorf("%d events, last = %d", len(list), last)
+	}
+	// a new connection of the same client
+	channels = &sync.Map{}
+	events.Attach(channels)
+	ziman.Process(append([]byte{}, report...), channels)
+	if list, last := events.Since(0); len(list) != 3 || last != 3 {
+		t.Errorf("%d events after reconnect, last = %d", len(list), last)
+	}
+}
+
+func TestEventsHistory(t *testing.T) {
+	channels := &sync.Map{}
+	report := ziman.FrameBytes(ziman.FUNC_ROTATE, 9, []byte{1, 2, 10, 1})
+	for i := 0; i < ziman.EVENTS_HISTORY+5; i++ {
+		ziman.Process(append([]byte{}, report...), channels)
+	}
+	list, last := ziman.EventsOf(channels).Since(0)
+	if len(list) != ziman.EVENTS_HISTORY || last != ziman.EVENTS_HISTORY+5 || list[0].Sequence != 6 {
+		t.Errorf("%d events, last = %d", len(list), last)
+	}
+}
+
+func TestEventsLateReply(t *testing.T) {
+	m, board := newMachine()
+	channels := board.GetChannels()
+	// set directly, not with SequenceOf
+	m.Sequence = &ziman.Sequence{}
+	board.MotorDuration = 100 * time.Millisecond
+	subscription, stop := ziman.EventsOf(channels).Subscribe(1)
+	defer stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := m.Rotate(ctx, 3, 4); err != ziman.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	select {
+	case event := <-subscription:
+		if reply := event.Reply(); event.Type != ziman.EVENT_LATE_REPLY || reply.Row != 3 || reply.Column != 4 {
+			t.Errorf("event = %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Error("no late reply")
+	}
+}
@@ -1,32 +1,46 @@
 package jsonrpc
 
 import (
-	"errors"
-	"fmt"
-	"log"
+	"context"
 	"sync"
 	"time"
 
+	"github.com/caiguanhao/vending-processors/idempotency"
 	"github.com/caiguanhao/vending-processors/ziman"
 )
 
 var (
-	ErrTimeout      = errors.New("timeout")
-	ErrProcessing   = errors.New("already processing")
-	ErrNoContent    = errors.New("no content")
-	ErrNoSuchClient = errors.New("no such client")
+	ErrTimeout      = ziman.ErrTimeout
+	ErrProcessing   = ziman.ErrProcessing
+	ErrNoContent    = ziman.ErrNoContent
+	ErrNoSuchClient = ziman.ErrNoSuchClient
 )
 
 type (
 	Ziman struct {
 		Clients *sync.Map
-	}
+		// optional, limits concurrent motor operations per board and lets
+		// other commands wait instead of failing with ErrProcessing
+		Scheduler *ziman.Scheduler
+		// optional, retries commands that fail with a timeout
+		Retry *ziman.RetryPolicy
+		// optional, keeps the statistics of the slots and disables a slot
+		// after too many failures
+		SlotHealth *ziman.SlotHealth
+		// enables SendRaw
+		ExpertMode bool
+		// milliseconds the reply of Rotate or Unlock is kept for its request
+		// ID, defaults to 600000
+		IdempotencyWindow int
+
+		results idempotency.Results
 
-	Client interface {
-		GetChannels() *sync.Map
-		Write([]byte) (int, error)
+		mu     sync.Mutex
+		events map[string]*ziman.Events
 	}
 
+	Client = ziman.Client
+
 	BasicArgs struct {
 		ClientID string `json:"client_id"`
 		Row      int    `json:"row"`
@@ -50,6 +64,8 @@ type (
 
 	CheckReply struct {
 		BasicReply
+		// see Ziman.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
 	}
 
 	LookUpArgs struct {
@@ -71,47 +87,154 @@ type (
 		ExpectedTemperature   int       `json:"expected_temperature"`
 		ActualTemperature     int       `json:"actual_temperature"`
 		RefrigeratorOperating bool      `json:"refrigerator_operating"`
+		// see Ziman.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
+	}
+
+	AttemptReply struct {
+		Command string    `json:"command"`
+		Time    time.Time `json:"time"`
+		// milliseconds
+		Duration int    `json:"duration"`
+		Error    string `json:"error"`
 	}
 
 	RotateArgs struct {
 		BasicArgs
+		// optional, a retry with the same ID gets the reply of the first
+		// call instead of driving the motor again, or
+		// idempotency.ErrMismatch if the other arguments differ
+		RequestID string `json:"request_id"`
 	}
 
 	RotateReply struct {
 		BasicReply
+		// see Ziman.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
 	}
 
 	UnlockArgs struct {
 		BasicArgs
+		// optional, a retry with the same ID gets the reply of the first
+		// call instead of driving the motor again, or
+		// idempotency.ErrMismatch if the other arguments differ
+		RequestID string `json:"request_id"`
 	}
 
 	UnlockReply struct {
 		BasicReply
+		// see Ziman.Retry
+		Attempts []AttemptReply `json:"attempts,omitempty"`
+	}
+
+	EventsArgs struct {
+		ClientID string `json:"client_id"`
+		// sequence of the last event received, only newer events are
+		// returned
+		Since int `json:"since"`
+	}
+
+	EventsReply struct {
+		Events []EventReply `json:"events"`
+		// value of since for the next call
+		Next int `json:"next"`
+	}
+
+	EventReply struct {
+		Sequence int       `json:"sequence"`
+		Time     time.Time `json:"time"`
+		Type     string    `json:"type"`
+		Function int       `json:"function"`
+		Bytes    ByteArray `json:"bytes"`
+		Hex      Hex       `json:"hex"`
+		// for check, rotate and unlock frames
+		Reply *BasicReply `json:"reply"`
 	}
 )
 
+// Machine returns the Go API of the client, which the RPC methods of Ziman
+// are built on.
+func (z *Ziman) Machine(clientId string) (*ziman.Machine, error) {
+	if z.Clients == nil {
+		return nil, ErrNoSuchClient
+	}
+	_client, ok := z.Clients.Load(clientId)
+	if !ok {
+		return nil, ErrNoSuchClient
+	}
+	client, ok := _client.(Client)
+	if !ok {
+		return nil, ErrNoSuchClient
+	}
+	z.eventsOf(clientId).Attach(client.GetChannels())
+	return &ziman.Machine{
+		ID:         clientId,
+		Client:     client,
+		Scheduler:  z.Scheduler,
+		Sequence:   ziman.SequenceOf(client.GetChannels()),
+		Retry:      z.Retry,
+		SlotHealth: z.SlotHealth,
+	}, nil
+}
+
+// eventsOf returns the events of the client_id, which are kept when the
+// client reconnects, until Forget is called.
+func (z *Ziman) eventsOf(clientId string) *ziman.Events {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	if z.events == nil {
+		z.events = map[string]*ziman.Events{}
+	}
+	events, ok := z.events[clientId]
+	if !ok {
+		events = &ziman.Events{}
+		z.events[clientId] = events
+	}
+	return events
+}
+
+// Forget drops the events of the client_id after it has disconnected, e.g.
+// when a client that is not identified by a handshake will come back with
+// another client_id.
+func (z *Ziman) Forget(clientId string) {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	delete(z.events, clientId)
+}
+
 func (z *Ziman) Check(args *CheckArgs, reply *CheckReply) error {
-	bytes, frame := bytesForData(ziman.FUNC_CHECK, []byte{byte(args.Row), byte(args.Column)})
-	key := fmt.Sprintf("%s-%d-%d-%d", ziman.KEY_CHECK, int(frame), args.Row, args.Column)
-	output, err := z.write(args.ClientID, bytes, key, args.Timeout)
+	m, err := z.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := timeoutContext(args.Timeout)
+	defer cancel()
+	attempts := recordAttempts(m)
+	r, err := m.Check(ctx, args.Row, args.Column)
 	if err != nil {
 		return err
 	}
 	*reply = CheckReply{
-		BytesToBasicReply(output[0]),
+		BasicReply: basicReply(r),
+		Attempts:   *attempts,
 	}
 	return nil
 }
 
 func (z *Ziman) LookUp(args *LookUpArgs, reply *LookUpReply) error {
-	bytes, _ := bytesForData(ziman.FUNC_LOOKUP, []byte{0x01, 0x01})
-	output, err := z.write(args.ClientID, bytes, ziman.KEY_LOOKUP, args.Timeout)
+	m, err := z.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := timeoutContext(args.Timeout)
+	defer cancel()
+	output, err := m.LookUp(ctx)
 	if err != nil {
 		return err
 	}
 	replies := []BasicReply{}
 	for _, item := range output {
-		replies = append(replies, BytesToBasicReply(item))
+		replies = append(replies, basicReply(item))
 	}
 	*reply = LookUpReply{
 		replies,
@@ -120,148 +243,153 @@ func (z *Ziman) LookUp(args *LookUpArgs, reply *LookUpReply) error {
 }
 
 func (z *Ziman) Status(args *StatusArgs, reply *StatusReply) error {
-	bytes, _ := bytesForData(ziman.FUNC_STATUS, []byte{byte(2), byte(2)})
-	output, err := z.write(args.ClientID, bytes, ziman.KEY_STATUS, args.Timeout)
+	m, err := z.Machine(args.ClientID)
 	if err != nil {
 		return err
 	}
-	*reply = BytesToStatusReply(output[0])
-	return nil
-}
-
-func (z *Ziman) Rotate(args *RotateArgs, reply *RotateReply) error {
-	bytes, frame := bytesForData(ziman.FUNC_ROTATE, []byte{byte(args.Row), byte(args.Column)})
-	key := fmt.Sprintf("%s-%d-%d-%d", ziman.KEY_ROTATE, int(frame), args.Row, args.Column)
-	output, err := z.write(args.ClientID, bytes, key, args.Timeout)
+	ctx, cancel := timeoutContext(args.Timeout)
+	defer cancel()
+	attempts := recordAttempts(m)
+	status, err := m.Status(ctx)
 	if err != nil {
 		return err
 	}
-	*reply = RotateReply{
-		BytesToBasicReply(output[0]),
-	}
+	*reply = statusReply(status)
+	reply.Attempts = *attempts
 	return nil
 }
 
+func (z *Ziman) Rotate(args *RotateArgs, reply *RotateReply) error {
+	return z.idempotent("Rotate", args.ClientID, args.RequestID, [2]int{args.Row, args.Column}, reply, func() error {
+		m, err := z.Machine(args.ClientID)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := timeoutContext(args.Timeout)
+		defer cancel()
+		attempts := recordAttempts(m)
+		r, err := m.Rotate(ctx, args.Row, args.Column)
+		if err != nil {
+			return err
+		}
+		*reply = RotateReply{
+			BasicReply: basicReply(r),
+			Attempts:   *attempts,
+		}
+		return nil
+	})
+}
+
 func (z *Ziman) Unlock(args *UnlockArgs, reply *UnlockReply) error {
-	bytes, frame := bytesForData(ziman.FUNC_UNLOCK, []byte{byte(args.Row), byte(args.Column)})
-	key := fmt.Sprintf("%s-%d-%d-%d", ziman.KEY_UNLOCK, int(frame), args.Row, args.Column)
-	output, err := z.write(args.ClientID, bytes, key, args.Timeout)
+	return z.idempotent("Unlock", args.ClientID, args.RequestID, [2]int{args.Row, args.Column}, reply, func() error {
+		m, err := z.Machine(args.ClientID)
+		if err != nil {
+			return err
+		}
+		ctx, cancel := timeoutContext(args.Timeout)
+		defer cancel()
+		attempts := recordAttempts(m)
+		r, err := m.Unlock(ctx, args.Row, args.Column)
+		if err != nil {
+			return err
+		}
+		*reply = UnlockReply{
+			BasicReply: basicReply(r),
+			Attempts:   *attempts,
+		}
+		return nil
+	})
+}
+
+// Events returns the frames received from the client that were not replies
+// of running commands, e.g. rotations that completed after the command
+// timed out. Only the latest ziman.EVENTS_HISTORY events are kept.
+func (z *Ziman) Events(args *EventsArgs, reply *EventsReply) error {
+	m, err := z.Machine(args.ClientID)
 	if err != nil {
 		return err
 	}
-	*reply = UnlockReply{
-		BytesToBasicReply(output[0]),
+	events, next := z.eventsOf(m.ID).Since(args.Since)
+	replies := []EventReply{}
+	for _, event := range events {
+		r := EventReply{
+			Sequence: event.Sequence,
+			Time:     event.Time,
+			Type:     event.Type,
+			Function: int(event.Function),
+			Bytes:    event.Bytes,
+			Hex:      event.Bytes,
+		}
+		switch event.Function {
+		case ziman.FUNC_CHECK, ziman.FUNC_ROTATE, ziman.FUNC_UNLOCK:
+			if len(event.Bytes) >= 8 {
+				basic := basicReply(event.Reply())
+				r.Reply = &basic
+			}
+		}
+		replies = append(replies, r)
+	}
+	*reply = EventsReply{
+		Events: replies,
+		Next:   next,
 	}
 	return nil
 }
 
-func (z *Ziman) write(clientId string, input []byte, channelKey string, timeout int) (output [][]byte, err error) {
-	if len(input) == 0 {
-		err = ErrNoContent
-		return
-	}
-	if z.Clients == nil {
-		err = ErrNoSuchClient
-		return
-	}
-	_client, ok := z.Clients.Load(clientId)
-	if !ok {
-		err = ErrNoSuchClient
-		return
-	}
-	client, ok := _client.(Client)
-	if !ok {
-		err = ErrNoSuchClient
-		return
-	}
-	channels := client.GetChannels()
-
-	bufferCapacity := 0
-	if channelKey == ziman.KEY_LOOKUP {
-		bufferCapacity = 5
-	}
-	channel, hasChannel := channels.LoadOrStore(channelKey, make(chan []byte, bufferCapacity))
-	if hasChannel {
-		err = ErrProcessing
-		return
-	} else {
-		defer channels.Delete(channelKey)
-	}
-	var n int
-	n, err = client.Write(input)
-	if clientId == "" {
-		log.Printf("%d bytes written: % X", n, input)
-	} else {
-		log.Printf("%s %d bytes written: % X", clientId, n, input)
-	}
-	if err == nil {
-		timeoutChan := newTimeoutChan(timeout)
-		for {
-			select {
-			case data := <-channel.(chan []byte):
-				output = append(output, data)
-				if bufferCapacity == 0 || len(output) == bufferCapacity {
-					return
-				}
-			case <-timeoutChan:
-				if bufferCapacity > 0 && len(output) > 0 {
-					// return results even if they are not full
-					return
-				}
-				err = ErrTimeout
-				return
-			}
+func BytesToBasicReply(input []byte) BasicReply {
+	return basicReply(ziman.ParseReply(input))
+}
+
+func BytesToStatusReply(input []byte) StatusReply {
+	return statusReply(ziman.ParseStatus(input))
+}
+
+// recordAttempts appends every try of the commands run by m to the returned
+// slice.
+func recordAttempts(m *ziman.Machine) *[]AttemptReply {
+	attempts := &[]AttemptReply{}
+	m.OnAttempt = func(attempt ziman.Attempt) {
+		reply := AttemptReply{
+			Command:  attempt.Command,
+			Time:     attempt.Time,
+			Duration: attempt.Duration,
 		}
-	} else {
-		log.Println("error writting", input, err)
+		if attempt.Error != nil {
+			reply.Error = attempt.Error.Error()
+		}
+		*attempts = append(*attempts, reply)
 	}
-	return
+	return attempts
 }
 
-func BytesToBasicReply(input []byte) BasicReply {
-	success := true
-	if len(input) == 10 {
-		success = input[7] == 1
-	}
+func basicReply(r ziman.Reply) BasicReply {
 	return BasicReply{
-		Bytes:    input,
-		Hex:      input,
-		Frame:    int(input[3]),
-		Row:      int(input[4]),
-		Column:   int(input[5]),
-		Duration: int(input[6]),
-		Success:  success,
+		Bytes:    r.Bytes,
+		Hex:      r.Bytes,
+		Frame:    r.Frame,
+		Row:      r.Row,
+		Column:   r.Column,
+		Duration: r.Duration,
+		Success:  r.Success,
 	}
 }
 
-func BytesToStatusReply(input []byte) StatusReply {
+func statusReply(status ziman.Status) StatusReply {
 	return StatusReply{
-		Time:                  time.Now(),
-		ExpectedTemperature:   int(input[4]),
-		ActualTemperature:     int(input[5]),
-		RefrigeratorOperating: input[6] == 1,
+		Time:                  status.Time,
+		ExpectedTemperature:   status.ExpectedTemperature,
+		ActualTemperature:     status.ActualTemperature,
+		RefrigeratorOperating: status.RefrigeratorOperating,
 	}
 }
 
-func bytesForData(function byte, data []byte) (out []byte, frame byte) {
-	_, min, sec := time.Now().Clock()
-	frame = byte((min*60 + sec) % 250)
-	size := 4 + 2 + len(data)
-	out = append([]byte{0xa8, byte(size), function, frame}, data...)
-	var sum byte
-	for i := 0; i < len(out); i++ {
-		sum += out[i]
-	}
-	out = append(out, sum&0xff, 0xfe)
-	return
-}
-
-func newTimeoutChan(t int) <-chan time.Time {
+// timeoutContext returns a context that expires after t milliseconds, or
+// one without deadline if t is 0 so that the default timeout applies.
+func timeoutContext(t int) (context.Context, context.CancelFunc) {
 	if t == 0 {
-		t = 10000
+		return context.WithCancel(context.Background())
 	} else if t < 100 {
 		t = 100
 	}
-	timeout := time.Duration(t) * time.Millisecond
-	return time.After(timeout)
+	return context.WithTimeout(context.Background(), time.Duration(t)*time.Millisecond)
 }
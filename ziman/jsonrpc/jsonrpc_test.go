@@ -0,0 +1,160 @@
+package jsonrpc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/fault"
+	"github.com/caiguanhao/vending-processors/idempotency"
+	"github.com/caiguanhao/vending-processors/ziman"
+	"github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func newZiman() (*Ziman, *sim.Board) {
+	board := sim.NewBoard()
+	board.MotorDuration = 50 * time.Millisecond
+	clients := &sync.Map{}
+	clients.Store("a", board)
+	return &Ziman{Clients: clients}, board
+}
+
+func TestEvents(t *testing.T) {
+	s, board := newZiman()
+	board.MotorDuration = 100 * time.Millisecond
+	m, err := s.Machine("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := m.Rotate(ctx, 2, 5); err != ziman.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	var reply EventsReply
+	deadline := time.Now().Add(time.Second)
+	for len(reply.Events) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("no events")
+		}
+		time.Sleep(20 * time.Millisecond)
+		if err := s.Events(&EventsArgs{ClientID: "a"}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	event := reply.Events[0]
+	if event.Type != ziman.EVENT_LATE_REPLY || event.Reply == nil || event.Reply.Row != 2 || event.Reply.Column != 5 || reply.Next != 1 {
+		t.Errorf("reply = %+v", reply)
+	}
+	if err := s.Events(&EventsArgs{ClientID: "a", Since: reply.Next}, &reply); err != nil || len(reply.Events) != 0 {
+		t.Errorf("events since %d = %+v, err = %v", reply.Next, reply.Events, err)
+	}
+	if err := s.Events(&EventsArgs{ClientID: "b"}, &reply); err != ErrNoSuchClient {
+		t.Errorf("err = %v, want ErrNoSuchClient", err)
+	}
+
+	// the client reconnects with new channels, the unsolicited report that
+	// arrives before the next call is kept with the history
+	reconnected := sim.NewBoard()
+	s.Clients.Store("a", reconnected)
+	ziman.Process(ziman.FrameBytes(ziman.FUNC_ROTATE, 9, []byte{1, 2, 10, 1}), reconnected.GetChannels())
+	if err := s.Events(&EventsArgs{ClientID: "a"}, &reply); err != nil || len(reply.Events) != 2 || reply.Next != 2 {
+		t.Fatalf("events after reconnect = %+v, err = %v", reply.Events, err)
+	}
+	if event := reply.Events[1]; event.Type != ziman.EVENT_UNMATCHED || event.Sequence != 2 {
+		t.Errorf("event = %+v", event)
+	}
+	s.Clients.Delete("a")
+	s.Forget("a")
+	s.Clients.Store("a", sim.NewBoard())
+	if err := s.Events(&EventsArgs{ClientID: "a"}, &reply); err != nil || len(reply.Events) != 0 {
+		t.Errorf("events after Forget = %+v, err = %v", reply.Events, err)
+	}
+}
+
+func TestRotateRequestID(t *testing.T) {
+	s, _ := newZiman()
+	args := &RotateArgs{BasicArgs: BasicArgs{ClientID: "a", Row: 1, Column: 2}, RequestID: "r1"}
+	var first, second RotateReply
+	if err := s.Rotate(args, &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rotate(args, &second); err != nil || second.Frame != first.Frame {
+		t.Errorf("second = %+v, first = %+v, err = %v", second, first, err)
+	}
+	// a retry may wait longer
+	retry := *args
+	retry.Timeout = 5000
+	if err := s.Rotate(&retry, &second); err != nil || second.Frame != first.Frame {
+		t.Errorf("retry with another timeout: second = %+v, err = %v", second, err)
+	}
+	other := &RotateArgs{BasicArgs: BasicArgs{ClientID: "a", Row: 1, Column: 3}, RequestID: "r1"}
+	if err := s.Rotate(other, &second); err != idempotency.ErrMismatch {
+		t.Errorf("err = %v, want ErrMismatch", err)
+	}
+	var unlock UnlockReply
+	if err := s.Unlock(&UnlockArgs{BasicArgs: args.BasicArgs, RequestID: "r1"}, &unlock); err != nil {
+		t.Errorf("unlock with the ID of another method: err = %v", err)
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	board := sim.NewBoard()
+	board.MotorDuration = 10 * time.Millisecond
+	// the first command is lost on the way to the board
+	client := &fault.Client{
+		Board: board,
+		Injector: fault.NewInjector(fault.Script{Rules: []fault.Rule{
+			{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DROP, Times: 1},
+		}}),
+	}
+	clients := &sync.Map{}
+	clients.Store("a", client)
+	retry := ziman.Retry{Attempts: 3, Backoff: 10, Timeout: 200}
+	s := &Ziman{Clients: clients, Retry: &ziman.RetryPolicy{Read: retry, Motor: retry}}
+	cell := BasicArgs{ClientID: "a", Row: 1, Column: 1}
+
+	var check CheckReply
+	if err := s.Check(&CheckArgs{BasicArgs: cell}, &check); err != nil || len(check.Attempts) != 2 {
+		t.Fatalf("check = %+v, err = %v", check, err)
+	}
+	if a := check.Attempts[0]; a.Command != ziman.KEY_CHECK || a.Error != ziman.ErrTimeout.Error() {
+		t.Errorf("check attempts = %+v", check.Attempts)
+	}
+
+	// a rotate that timed out may have run, even if LookUp misses its reply
+	client.Injector = fault.NewInjector(fault.Script{Rules: []fault.Rule{
+		{Direction: fault.DIRECTION_OUTBOUND, Action: fault.ACTION_DROP, Times: 1},
+	}})
+	var rotate RotateReply
+	if err := s.Rotate(&RotateArgs{BasicArgs: cell}, &rotate); err != ziman.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	if err := s.Rotate(&RotateArgs{BasicArgs: cell}, &rotate); err != nil || !rotate.Success || len(rotate.Attempts) != 1 {
+		t.Errorf("rotate = %+v, err = %v", rotate, err)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	s, board := newZiman()
+	s.SlotHealth = &ziman.SlotHealth{MaxFailures: 1}
+	board.Fail(1, 2, true)
+	cell := BasicArgs{ClientID: "a", Row: 1, Column: 2}
+	var ok bool
+	if err := s.EnableSlot(&cell, &ok); err != ziman.ErrSlotNotTracked {
+		t.Errorf("enable untracked: err = %v, want ErrSlotNotTracked", err)
+	}
+	var rotate RotateReply
+	s.Rotate(&RotateArgs{BasicArgs: cell}, &rotate)
+	if err := s.Rotate(&RotateArgs{BasicArgs: cell}, &rotate); err != ziman.ErrSlotDisabled {
+		t.Errorf("err = %v, want ErrSlotDisabled", err)
+	}
+	var slots SlotsReply
+	if err := s.Slots(&BasicArgs{ClientID: "a"}, &slots); err != nil || len(slots.Slots) != 1 || !slots.Slots[0].Disabled {
+		t.Fatalf("slots = %+v, err = %v", slots, err)
+	}
+	if err := s.EnableSlot(&cell, &ok); err != nil || !ok {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+}
@@ -0,0 +1,75 @@
+package jsonrpc
+
+import (
+	"errors"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+var (
+	ErrExpertMode = errors.New("expert mode is off")
+	ErrNoInput    = errors.New("hex or function is required")
+)
+
+type (
+	SendRawArgs struct {
+		ClientID string `json:"client_id"`
+		// frame to send as is
+		Hex Hex `json:"hex"`
+		// if hex is empty, the frame is built from function and data with
+		// the next frame number of the client
+		Function int `json:"function"`
+		Data     Hex `json:"data"`
+		// channel key of the reply, defaults to the key of the function of
+		// the frame (see ziman.FunctionKey), which receives any reply with
+		// that function that no other command is waiting for
+		Key string `json:"key"`
+		// milliseconds, defaults to 10000
+		Timeout int `json:"timeout"`
+	}
+
+	SendRawReply struct {
+		Bytes ByteArray `json:"bytes"`
+		Hex   Hex       `json:"hex"`
+	}
+)
+
+// SendRaw sends any frame and returns the raw reply. It is only available
+// when ExpertMode is on, as a wrong command may damage the machine.
+func (z *Ziman) SendRaw(args *SendRawArgs, reply *SendRawReply) error {
+	if !z.ExpertMode {
+		return ErrExpertMode
+	}
+	m, err := z.Machine(args.ClientID)
+	if err != nil {
+		return err
+	}
+	input := []byte(args.Hex)
+	switch {
+	case len(input) > 2:
+	case args.Function != 0:
+		frame, done, err := m.Sequence.Next()
+		if err != nil {
+			return err
+		}
+		defer done()
+		input = ziman.FrameBytes(byte(args.Function), frame, args.Data)
+	default:
+		return ErrNoInput
+	}
+	key := args.Key
+	if key == "" {
+		key = ziman.FunctionKey(input[2])
+	}
+	ctx, cancel := timeoutContext(args.Timeout)
+	defer cancel()
+	output, err := m.SendRaw(ctx, input, key)
+	if err != nil {
+		return err
+	}
+	*reply = SendRawReply{
+		Bytes: output,
+		Hex:   output,
+	}
+	return nil
+}
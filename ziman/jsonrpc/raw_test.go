@@ -0,0 +1,36 @@
+package jsonrpc
+
+import (
+	"testing"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+func TestSendRaw(t *testing.T) {
+	s, board := newZiman()
+	var reply SendRawReply
+	if err := s.SendRaw(&SendRawArgs{ClientID: "a", Function: ziman.FUNC_STATUS}, &reply); err != ErrExpertMode {
+		t.Errorf("err = %v, want ErrExpertMode", err)
+	}
+	s.ExpertMode = true
+	if err := s.SendRaw(&SendRawArgs{ClientID: "a"}, &reply); err != ErrNoInput {
+		t.Errorf("err = %v, want ErrNoInput", err)
+	}
+
+	board.SetTemperature(3, 9, true)
+	if err := s.SendRaw(&SendRawArgs{ClientID: "a", Function: ziman.FUNC_STATUS, Data: Hex{0x02, 0x02}}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if status := ziman.ParseStatus(reply.Bytes); status.ExpectedTemperature != 3 || status.ActualTemperature != 9 {
+		t.Errorf("status = % X", []byte(reply.Hex))
+	}
+
+	// a frame as is, with the key from its function
+	frame := ziman.FrameBytes(ziman.FUNC_CHECK, 200, []byte{4, 5})
+	if err := s.SendRaw(&SendRawArgs{ClientID: "a", Hex: frame}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if r := ziman.ParseReply(reply.Bytes); r.Frame != 200 || r.Row != 4 || r.Column != 5 {
+		t.Errorf("check = % X", []byte(reply.Hex))
+	}
+}
@@ -0,0 +1,75 @@
+package jsonrpc
+
+import (
+	"errors"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+var ErrNoSlotHealth = errors.New("slot health is not tracked")
+
+type (
+	SlotsReply struct {
+		Slots []SlotReply `json:"slots"`
+	}
+
+	SlotReply struct {
+		Row                 int `json:"row"`
+		Column              int `json:"column"`
+		Attempts            int `json:"attempts"`
+		Failures            int `json:"failures"`
+		ConsecutiveFailures int `json:"consecutive_failures"`
+		// see ziman.SlotStats
+		AverageDuration float64   `json:"average_duration"`
+		LastErrorCode   string    `json:"last_error_code"`
+		LastAttemptAt   time.Time `json:"last_attempt_at"`
+		Disabled        bool      `json:"disabled"`
+		DisabledAt      time.Time `json:"disabled_at"`
+	}
+)
+
+// Slots returns the statistics of the cells of the client that have been
+// rotated or unlocked, see Ziman.SlotHealth.
+func (z *Ziman) Slots(args *BasicArgs, reply *SlotsReply) error {
+	if z.SlotHealth == nil {
+		return ErrNoSlotHealth
+	}
+	slots := []SlotReply{}
+	for _, stats := range z.SlotHealth.Stats(args.ClientID) {
+		slots = append(slots, slotReply(stats))
+	}
+	*reply = SlotsReply{
+		Slots: slots,
+	}
+	return nil
+}
+
+// EnableSlot enables the cell that has been disabled after too many
+// failures. It fails with ziman.ErrSlotNotTracked if the cell has not been
+// rotated or unlocked.
+func (z *Ziman) EnableSlot(args *BasicArgs, reply *bool) error {
+	if z.SlotHealth == nil {
+		return ErrNoSlotHealth
+	}
+	if err := z.SlotHealth.Enable(args.ClientID, args.Row, args.Column); err != nil {
+		return err
+	}
+	*reply = true
+	return nil
+}
+
+func slotReply(stats ziman.SlotStats) SlotReply {
+	return SlotReply{
+		Row:                 stats.Row,
+		Column:              stats.Column,
+		Attempts:            stats.Attempts,
+		Failures:            stats.Failures,
+		ConsecutiveFailures: stats.ConsecutiveFailures,
+		AverageDuration:     stats.AverageDuration,
+		LastErrorCode:       stats.LastErrorCode,
+		LastAttemptAt:       stats.LastAttemptAt,
+		Disabled:            stats.Disabled,
+		DisabledAt:          stats.DisabledAt,
+	}
+}
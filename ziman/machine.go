@@ -0,0 +1,295 @@
+package ziman
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeout      = errors.New("timeout")
+	ErrProcessing   = errors.New("already processing")
+	ErrNoContent    = errors.New("no content")
+	ErrNoSuchClient = errors.New("no such client")
+)
+
+type (
+	Client interface {
+		GetChannels() *sync.Map
+		Write([]byte) (int, error)
+	}
+
+	// Machine sends commands to one ziman board. Every method waits for the
+	// reply until ctx is done, or 10 seconds if ctx has no deadline.
+	Machine struct {
+		// name of the client in logs
+		ID     string
+		Client Client
+		// optional, see Scheduler
+		Scheduler *Scheduler
+		// optional, allocates the frame numbers of Check, Rotate and Unlock,
+		// which are derived from the clock if it is nil
+		Sequence *Sequence
+		// optional, see RetryPolicy
+		Retry *RetryPolicy
+		// optional, see SlotHealth
+		SlotHealth *SlotHealth
+		// optional, called after every try of Check, Status, Rotate and
+		// Unlock
+		OnAttempt func(Attempt)
+	}
+
+	Reply struct {
+		Bytes    []byte
+		Frame    int
+		Row      int
+		Column   int
+		Duration int
+		Success  bool
+	}
+
+	Status struct {
+		Time                  time.Time
+		ExpectedTemperature   int
+		ActualTemperature     int
+		RefrigeratorOperating bool
+	}
+)
+
+func (m *Machine) Check(ctx context.Context, row, column int) (Reply, error) {
+	return m.cellCommand(ctx, FUNC_CHECK, KEY_CHECK, row, column)
+}
+
+// LookUp returns the replies that arrive before ctx is done, at most 5.
+func (m *Machine) LookUp(ctx context.Context) ([]Reply, error) {
+	bytes, _ := bytesForData(FUNC_LOOKUP, []byte{0x01, 0x01})
+	output, err := m.write(ctx, bytes, KEY_LOOKUP)
+	if err != nil {
+		return nil, err
+	}
+	replies := []Reply{}
+	for _, item := range output {
+		replies = append(replies, ParseReply(item))
+	}
+	return replies, nil
+}
+
+func (m *Machine) Status(ctx context.Context) (Status, error) {
+	var output [][]byte
+	err := m.retry(ctx, RETRY_READ, KEY_STATUS, func(ctx context.Context) (err error) {
+		bytes, _ := bytesForData(FUNC_STATUS, []byte{byte(2), byte(2)})
+		output, err = m.write(ctx, bytes, KEY_STATUS)
+		return
+	})
+	if err != nil {
+		return Status{}, err
+	}
+	return ParseStatus(output[0]), nil
+}
+
+func (m *Machine) Rotate(ctx context.Context, row, column int) (Reply, error) {
+	return m.cellCommand(ctx, FUNC_ROTATE, KEY_ROTATE, row, column)
+}
+
+func (m *Machine) Unlock(ctx context.Context, row, column int) (Reply, error) {
+	return m.cellCommand(ctx, FUNC_UNLOCK, KEY_UNLOCK, row, column)
+}
+
+func (m *Machine) cellCommand(ctx context.Context, function byte, key string, row, column int) (reply Reply, err error) {
+	class := RETRY_READ
+	if function != FUNC_CHECK {
+		class = RETRY_MOTOR
+		if m.SlotHealth != nil {
+			var release func()
+			if release, err = m.SlotHealth.reserve(ctx, m.ID, row, column); err != nil {
+				return
+			}
+			defer release()
+		}
+		defer func() {
+			m.recordSlot(row, column, reply, err)
+		}()
+	}
+	err = m.retry(ctx, class, key, func(ctx context.Context) (err error) {
+		reply, err = m.cellAttempt(ctx, function, key, row, column)
+		return
+	})
+	return
+}
+
+// recordSlot adds the result of a motor command to the cell with the time
+// the motor ran as reported by the board, in 100 milliseconds, unless the
+// command failed before it was sent.
+func (m *Machine) recordSlot(row, column int, reply Reply, err error) {
+	if m.SlotHealth == nil {
+		return
+	}
+	if err != nil {
+		if err == ErrTimeout {
+			m.SlotHealth.record(m.ID, row, column, -1, false, err.Error())
+		}
+		return
+	}
+	m.SlotHealth.record(m.ID, row, column, reply.Duration*100, reply.Success, fmt.Sprintf("%X", reply.Bytes))
+}
+
+func (m *Machine) cellAttempt(ctx context.Context, function byte, key string, row, column int) (Reply, error) {
+	data := []byte{byte(row), byte(column)}
+	var bytes []byte
+	var frame byte
+	if m.Sequence == nil {
+		bytes, frame = bytesForData(function, data)
+	} else {
+		var done func()
+		var err error
+		m.Sequence.use(m.Client.GetChannels())
+		frame, done, err = m.Sequence.Next()
+		if err != nil {
+			return Reply{}, err
+		}
+		// the frame is quarantined once the reply is received or given up
+		defer done()
+		bytes = FrameBytes(function, frame, data)
+	}
+	key = fmt.Sprintf("%s-%d-%d-%d", key, int(frame), row, column)
+	output, err := m.write(ctx, bytes, key)
+	if err != nil {
+		return Reply{}, err
+	}
+	return ParseReply(output[0]), nil
+}
+
+// SendRaw writes input as is and returns the first reply dispatched to key,
+// e.g. FunctionKey. It is meant for trying undocumented commands.
+func (m *Machine) SendRaw(ctx context.Context, input []byte, key string) ([]byte, error) {
+	output, err := m.write(ctx, input, key)
+	if err != nil {
+		return nil, err
+	}
+	return output[0], nil
+}
+
+func ParseReply(input []byte) Reply {
+	success := true
+	if len(input) == 10 {
+		success = input[7] == 1
+	}
+	return Reply{
+		Bytes:    input,
+		Frame:    int(input[3]),
+		Row:      int(input[4]),
+		Column:   int(input[5]),
+		Duration: int(input[6]),
+		Success:  success,
+	}
+}
+
+func ParseStatus(input []byte) Status {
+	return Status{
+		Time:                  time.Now(),
+		ExpectedTemperature:   int(input[4]),
+		ActualTemperature:     int(input[5]),
+		RefrigeratorOperating: input[6] == 1,
+	}
+}
+
+func (m *Machine) write(ctx context.Context, input []byte, channelKey string) (output [][]byte, err error) {
+	if len(input) == 0 {
+		err = ErrNoContent
+		return
+	}
+	if m.Client == nil {
+		err = ErrNoSuchClient
+		return
+	}
+	if m.Scheduler != nil {
+		var release func()
+		release, err = m.Scheduler.Acquire(ctx, m.ID, channelKey)
+		if err != nil {
+			return
+		}
+		defer release()
+	}
+	channels := m.Client.GetChannels()
+
+	bufferCapacity := 0
+	if channelKey == KEY_LOOKUP {
+		bufferCapacity = 5
+	}
+	// at least 1 so the reply is kept if it arrives before the select below
+	channel, hasChannel := channels.LoadOrStore(channelKey, make(chan []byte, bufferCapacity+1))
+	if hasChannel {
+		err = ErrProcessing
+		return
+	} else {
+		defer channels.Delete(channelKey)
+	}
+	ctx, cancel := withTimeout(ctx)
+	defer cancel()
+	var n int
+	n, err = m.Client.Write(input)
+	if m.ID == "" {
+		log.Printf("%d bytes written: % X", n, input)
+	} else {
+		log.Printf("%s %d bytes written: % X", m.ID, n, input)
+	}
+	if err == nil {
+		for {
+			select {
+			case data := <-channel.(chan []byte):
+				output = append(output, data)
+				if bufferCapacity == 0 || len(output) == bufferCapacity {
+					return
+				}
+			case <-ctx.Done():
+				if bufferCapacity > 0 && len(output) > 0 {
+					// return results even if they are not full
+					return
+				}
+				err = contextError(ctx)
+				return
+			}
+		}
+	} else {
+		log.Println("error writting", input, err)
+	}
+	return
+}
+
+func bytesForData(function byte, data []byte) (out []byte, frame byte) {
+	_, min, sec := time.Now().Clock()
+	frame = byte((min*60 + sec) % 250)
+	out = FrameBytes(function, frame, data)
+	return
+}
+
+// FrameBytes returns the 0xA8 ... 0xFE frame, which is used for both commands
+// and replies.
+func FrameBytes(function, frame byte, data []byte) (out []byte) {
+	size := 4 + 2 + len(data)
+	out = append([]byte{0xa8, byte(size), function, frame}, data...)
+	var sum byte
+	for i := 0; i < len(out); i++ {
+		sum += out[i]
+	}
+	out = append(out, sum&0xff, 0xfe)
+	return
+}
+
+func withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, 10*time.Second)
+}
+
+// contextError returns ErrTimeout if the deadline of ctx is exceeded.
+func contextError(ctx context.Context) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrTimeout
+	}
+	return ctx.Err()
+}
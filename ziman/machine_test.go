@@ -0,0 +1,57 @@
+package ziman_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+	"github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func newMachine() (*ziman.Machine, *sim.Board) {
+	board := sim.NewBoard()
+	board.MotorDuration = 50 * time.Millisecond
+	return &ziman.Machine{ID: "a", Client: board, Sequence: &ziman.Sequence{}}, board
+}
+
+func TestMachineRotate(t *testing.T) {
+	m, board := newMachine()
+	ctx := context.Background()
+	reply, err := m.Rotate(ctx, 2, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reply.Success || reply.Row != 2 || reply.Column != 3 {
+		t.Errorf("reply = %+v", reply)
+	}
+	board.Fail(2, 4, true)
+	if reply, err := m.Unlock(ctx, 2, 4); err != nil || reply.Success {
+		t.Errorf("failing cell: reply = %+v, err = %v", reply, err)
+	}
+	if _, err := m.Check(ctx, 1, 1); err != nil {
+		t.Errorf("check: err = %v", err)
+	}
+}
+
+func TestMachineStatus(t *testing.T) {
+	m, board := newMachine()
+	board.SetTemperature(4, 6, false)
+	status, err := m.Status(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ExpectedTemperature != 4 || status.ActualTemperature != 6 || status.RefrigeratorOperating {
+		t.Errorf("status = %+v", status)
+	}
+}
+
+func TestMachineTimeout(t *testing.T) {
+	m, board := newMachine()
+	board.MotorDuration = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.Rotate(ctx, 1, 1); err != ziman.ErrTimeout {
+		t.Errorf("err = %v, want ErrTimeout", err)
+	}
+}
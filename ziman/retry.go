@@ -0,0 +1,48 @@
+package ziman
+
+import (
+	"context"
+
+	"github.com/caiguanhao/vending-processors/retry"
+)
+
+const (
+	// commands that only read, i.e. Check and Status
+	RETRY_READ = retry.READ
+	// commands that drive a motor, i.e. Rotate and Unlock
+	RETRY_MOTOR = retry.MOTOR
+)
+
+type (
+	Retry = retry.Retry
+
+	// RetryPolicy retries commands that fail with ErrTimeout, which is often
+	// transient, or with an error returned before they are written, e.g.
+	// ErrQueueTimeout. Read commands are retried after either. Motor
+	// commands are only retried after an error returned before they are
+	// written, since nothing tells whether the board has run one that timed
+	// out: a reply missing from LookUp may have been lost too.
+	RetryPolicy = retry.Policy
+
+	Attempt = retry.Attempt
+)
+
+// retry calls command as allowed by the RetryPolicy of m.
+func (m *Machine) retry(ctx context.Context, class, name string, command func(context.Context) error) error {
+	return m.Retry.Do(ctx, retry.Command{
+		Class:     class,
+		Name:      name,
+		Run:       command,
+		Transient: isTransient,
+		NotSent:   isNotSent,
+	}, m.OnAttempt)
+}
+
+func isTransient(err error) bool {
+	return err == ErrTimeout
+}
+
+// isNotSent reports whether err is returned before a command is written.
+func isNotSent(err error) bool {
+	return err == ErrProcessing || err == ErrQueueTimeout || err == ErrNoFrame
+}
@@ -0,0 +1,149 @@
+package ziman
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrQueueTimeout = errors.New("timed out waiting in queue")
+)
+
+type (
+	// Scheduler limits how many motor operations (rotate and unlock) a board
+	// runs at the same time. Motor operations over the limit wait in arrival
+	// order. Other commands never wait for motors, they only wait for an
+	// earlier command with the same channel key to finish.
+	Scheduler struct {
+		// motor operations a board may run at the same time, defaults to 1
+		MaxMotors int
+		// milliseconds a command may wait for its turn, 0 means no limit
+		MaxWait int
+
+		mu     sync.Mutex
+		boards map[string]*board
+	}
+
+	board struct {
+		motors  int
+		keys    map[string]bool
+		waiting []*scheduled
+	}
+
+	scheduled struct {
+		key   string
+		motor bool
+		ready chan struct{}
+	}
+)
+
+// IsMotorKey reports whether the channel key belongs to a rotate or unlock
+// command.
+func IsMotorKey(key string) bool {
+	return strings.HasPrefix(key, KEY_ROTATE+"-") || strings.HasPrefix(key, KEY_UNLOCK+"-")
+}
+
+// Acquire waits until the command with the channel key can be sent to the
+// client or ctx is done and returns a function that must be called when the
+// command is done.
+func (s *Scheduler) Acquire(ctx context.Context, clientId, key string) (release func(), err error) {
+	s.mu.Lock()
+	if s.boards == nil {
+		s.boards = map[string]*board{}
+	}
+	b, ok := s.boards[clientId]
+	if !ok {
+		b = &board{keys: map[string]bool{}}
+		s.boards[clientId] = b
+	}
+	item := &scheduled{
+		key:   key,
+		motor: IsMotorKey(key),
+		ready: make(chan struct{}),
+	}
+	release = func() {
+		s.release(clientId, b, item)
+	}
+	b.waiting = append(b.waiting, item)
+	s.schedule(b)
+	s.mu.Unlock()
+
+	var timeout <-chan time.Time
+	if s.MaxWait > 0 {
+		timer := time.NewTimer(time.Duration(s.MaxWait) * time.Millisecond)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case <-item.ready:
+		return
+	case <-timeout:
+		err = ErrQueueTimeout
+	case <-ctx.Done():
+		err = contextError(ctx)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, w := range b.waiting {
+		if w == item {
+			b.waiting = append(b.waiting[:i], b.waiting[i+1:]...)
+			s.cleanup(clientId, b)
+			return nil, err
+		}
+	}
+	// our turn came right after the timeout
+	return release, nil
+}
+
+// Len returns the number of commands waiting for the client.
+func (s *Scheduler) Len(clientId string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if b, ok := s.boards[clientId]; ok {
+		return len(b.waiting)
+	}
+	return 0
+}
+
+func (s *Scheduler) maxMotors() int {
+	if s.MaxMotors < 1 {
+		return 1
+	}
+	return s.MaxMotors
+}
+
+func (s *Scheduler) schedule(b *board) {
+	waiting := b.waiting[:0]
+	for _, item := range b.waiting {
+		if b.keys[item.key] || (item.motor && b.motors >= s.maxMotors()) {
+			waiting = append(waiting, item)
+			continue
+		}
+		b.keys[item.key] = true
+		if item.motor {
+			b.motors++
+		}
+		close(item.ready)
+	}
+	b.waiting = waiting
+}
+
+func (s *Scheduler) release(clientId string, b *board, item *scheduled) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(b.keys, item.key)
+	if item.motor {
+		b.motors--
+	}
+	s.schedule(b)
+	s.cleanup(clientId, b)
+}
+
+func (s *Scheduler) cleanup(clientId string, b *board) {
+	if len(b.keys) == 0 && len(b.waiting) == 0 {
+		delete(s.boards, clientId)
+	}
+}
@@ -0,0 +1,106 @@
+package ziman_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+	"github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func TestSchedulerMotors(t *testing.T) {
+	s := &ziman.Scheduler{MaxMotors: 1}
+	ctx := context.Background()
+	release, err := s.Acquire(ctx, "a", "rotate-1-1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// other commands do not wait for motors
+	releaseStatus, err := s.Acquire(ctx, "a", ziman.KEY_STATUS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	releaseStatus()
+	acquired := make(chan struct{})
+	go func() {
+		release, err := s.Acquire(ctx, "a", "unlock-2-1-2")
+		if err != nil {
+			t.Error(err)
+		} else {
+			release()
+		}
+		close(acquired)
+	}()
+	waitFor(t, func() bool { return s.Len("a") == 1 })
+	select {
+	case <-acquired:
+		t.Fatal("second motor started while the first is running")
+	case <-time.After(20 * time.Millisecond):
+	}
+	release()
+	<-acquired
+	if n := s.Len("a"); n != 0 {
+		t.Errorf("len = %d, want 0", n)
+	}
+}
+
+func TestSchedulerTimeout(t *testing.T) {
+	s := &ziman.Scheduler{MaxWait: 30}
+	ctx := context.Background()
+	release, err := s.Acquire(ctx, "a", "rotate-1-1-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	if _, err := s.Acquire(ctx, "a", "rotate-2-1-2"); err != ziman.ErrQueueTimeout {
+		t.Errorf("err = %v, want ErrQueueTimeout", err)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := s.Acquire(ctx, "a", "rotate-3-1-3"); err != context.Canceled {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+func TestSchedulerMachine(t *testing.T) {
+	board := sim.NewBoard()
+	board.MotorDuration = 50 * time.Millisecond
+	s := &ziman.Scheduler{MaxMotors: 2}
+	sequence := &ziman.Sequence{}
+	var wg sync.WaitGroup
+	start := time.Now()
+	for column := 1; column <= 4; column++ {
+		wg.Add(1)
+		go func(column int) {
+			defer wg.Done()
+			m := &ziman.Machine{
+				ID:        "a",
+				Client:    board,
+				Scheduler: s,
+				Sequence:  sequence,
+			}
+			reply, err := m.Rotate(context.Background(), 1, column)
+			if err != nil || !reply.Success {
+				t.Errorf("column %d: reply = %+v, err = %v", column, reply, err)
+			}
+		}(column)
+	}
+	wg.Wait()
+	// two rounds of two motors
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("elapsed = %v, want at least 100ms", elapsed)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
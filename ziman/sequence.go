@@ -0,0 +1,107 @@
+package ziman
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// frame numbers are 0 to 249
+const FRAME_COUNT = 250
+
+var ErrNoFrame = errors.New("no free frame number")
+
+// sequenceKey is where the channels of a client keep its Sequence, so that
+// Process can tell a late reply and the Sequence goes with the client.
+type sequenceKey struct{}
+
+// Sequence allocates the frame numbers of the commands to one board. A frame
+// number is not reused while its command is waiting for the reply, nor for
+// Quarantine after that, so a late reply can not be taken as the reply of a
+// newer command.
+type Sequence struct {
+	// milliseconds, defaults to 30000
+	Quarantine int
+
+	mu       sync.Mutex
+	next     int
+	inFlight map[byte]bool
+	expired  map[byte]time.Time
+}
+
+// SequenceOf returns the Sequence of the client with the channels, it is
+// created if there is none.
+func SequenceOf(channels *sync.Map) *Sequence {
+	sequence, _ := channels.LoadOrStore(sequenceKey{}, &Sequence{})
+	return sequence.(*Sequence)
+}
+
+// use makes s the Sequence of the client with the channels, e.g. when it is
+// set as Machine.Sequence without SequenceOf.
+func (s *Sequence) use(channels *sync.Map) {
+	if current, ok := channels.Load(sequenceKey{}); !ok || current != s {
+		channels.Store(sequenceKey{}, s)
+	}
+}
+
+// Next returns the next free frame number and a function that must be called
+// when its command is done.
+func (s *Sequence) Next() (frame byte, done func(), err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.inFlight == nil {
+		s.inFlight = map[byte]bool{}
+		s.expired = map[byte]time.Time{}
+	}
+	now := time.Now()
+	for i := 0; i < FRAME_COUNT; i++ {
+		candidate := byte((s.next + i) % FRAME_COUNT)
+		if s.inFlight[candidate] || s.quarantined(candidate, now) {
+			continue
+		}
+		delete(s.expired, candidate)
+		s.inFlight[candidate] = true
+		s.next = (int(candidate) + 1) % FRAME_COUNT
+		var once sync.Once
+		return candidate, func() {
+			once.Do(func() {
+				s.done(candidate)
+			})
+		}, nil
+	}
+	return 0, nil, ErrNoFrame
+}
+
+func (s *Sequence) InFlight(frame byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.inFlight[frame]
+}
+
+// Expired reports whether the command of the frame is done and the frame is
+// still in quarantine, a reply with such frame is a late reply.
+func (s *Sequence) Expired(frame byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.quarantined(frame, time.Now())
+}
+
+func (s *Sequence) done(frame byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inFlight, frame)
+	s.expired[frame] = time.Now()
+}
+
+// quarantined must be called with the lock held.
+func (s *Sequence) quarantined(frame byte, now time.Time) bool {
+	at, ok := s.expired[frame]
+	if !ok {
+		return false
+	}
+	quarantine := s.Quarantine
+	if quarantine == 0 {
+		quarantine = 30000
+	}
+	return now.Sub(at) < time.Duration(quarantine)*time.Millisecond
+}
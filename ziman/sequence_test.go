@@ -0,0 +1,71 @@
+package ziman_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+func TestSequenceNext(t *testing.T) {
+	s := &ziman.Sequence{Quarantine: 50}
+	first, done, err := s.Next()
+	if err != nil || first != 0 || !s.InFlight(first) {
+		t.Fatalf("frame = %d, err = %v", first, err)
+	}
+	done()
+	done() // only the first call counts
+	if s.InFlight(first) || !s.Expired(first) {
+		t.Error("frame is not in quarantine after done")
+	}
+	// the rest are taken before the quarantined frame is reused
+	for i := 1; i < ziman.FRAME_COUNT; i++ {
+		if frame, _, err := s.Next(); err != nil || int(frame) != i {
+			t.Fatalf("frame = %d, err = %v, want %d", frame, err, i)
+		}
+	}
+	if _, _, err := s.Next(); err != ziman.ErrNoFrame {
+		t.Errorf("err = %v, want ErrNoFrame", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if frame, _, err := s.Next(); err != nil || frame != first || s.Expired(first) {
+		t.Errorf("frame = %d, err = %v, want %d after the quarantine", frame, err, first)
+	}
+}
+
+func TestSequenceOf(t *testing.T) {
+	channels := &sync.Map{}
+	s := ziman.SequenceOf(channels)
+	if ziman.SequenceOf(channels) != s {
+		t.Error("another sequence for the same client")
+	}
+	if ziman.SequenceOf(&sync.Map{}) == s {
+		t.Error("the same sequence for another client")
+	}
+	// the sequence of a Machine is used by the client once it sends a command
+	m, board := newMachine()
+	if _, err := m.Rotate(context.Background(), 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	if ziman.SequenceOf(board.GetChannels()) != m.Sequence {
+		t.Error("the sequence of the machine is not used")
+	}
+}
+
+func TestSequenceLateReply(t *testing.T) {
+	m, board := newMachine()
+	board.MotorDuration = 100 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, err := m.Rotate(ctx, 1, 1); err != ziman.ErrTimeout {
+		t.Fatalf("err = %v, want ErrTimeout", err)
+	}
+	// the next command gets another frame and is not confused by the late
+	// reply of the first one
+	reply, err := m.Rotate(context.Background(), 1, 2)
+	if err != nil || reply.Column != 2 || reply.Frame == 0 {
+		t.Errorf("reply = %+v, err = %v", reply, err)
+	}
+}
@@ -0,0 +1,206 @@
+// Package sim simulates a ziman locker or spiral board, so that ziman/jsonrpc
+// can be used without real hardware.
+package sim
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/fault"
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+type (
+	// Board implements the Client interface of ziman/jsonrpc. Replies have
+	// the lengths ziman.Process expects and are fed back through it. Use
+	// NewBoard to get a board with default settings.
+	Board struct {
+		// defaults to 6 rows and 10 columns
+		Rows    int
+		Columns int
+		// time before each reply, defaults to 20 milliseconds
+		Delay time.Duration
+		// time a motor runs or a lock opens, defaults to 1 second
+		MotorDuration time.Duration
+		// chance (0 to 1) that a rotate or unlock fails
+		FailureRate float64
+		// seed of the random failures
+		Seed int64
+		// optional, applies the inbound rules to the replies
+		Faults *fault.Injector
+
+		channels sync.Map
+
+		replyMu  sync.Mutex
+		received []byte
+
+		mu                  sync.Mutex
+		input               []byte
+		rand                *rand.Rand
+		failing             map[[2]int]bool
+		history             [][]byte
+		expectedTemperature int
+		actualTemperature   int
+		refrigerator        bool
+	}
+)
+
+func NewBoard() *Board {
+	return &Board{
+		Rows:                6,
+		Columns:             10,
+		Delay:               20 * time.Millisecond,
+		MotorDuration:       time.Second,
+		expectedTemperature: 5,
+		actualTemperature:   8,
+		refrigerator:        true,
+	}
+}
+
+func (b *Board) GetChannels() *sync.Map {
+	return &b.channels
+}
+
+// Write receives commands from the host, replies are sent after Delay.
+func (b *Board) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.input = append(b.input, p...)
+	for len(b.input) > 0 {
+		if b.input[0] == 0xa8 {
+			if len(b.input) < 2 {
+				break
+			}
+			size := int(b.input[1])
+			if size >= ziman.MIN_FRAME_SIZE && len(b.input) < size {
+				break
+			}
+			if size >= ziman.MIN_FRAME_SIZE && valid(b.input[:size]) {
+				frame := b.input[:size]
+				b.command(frame[2], frame[3], append([]byte{}, frame[4:size-2]...))
+				b.input = b.input[size:]
+				continue
+			}
+		}
+		b.input = b.input[1:]
+	}
+	return len(p), nil
+}
+
+// Fail makes every rotate or unlock of the cell fail.
+func (b *Board) Fail(row, column int, failing bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failing == nil {
+		b.failing = map[[2]int]bool{}
+	}
+	b.failing[[2]int{row, column}] = failing
+}
+
+func (b *Board) SetTemperature(expected, actual int, refrigerator bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expectedTemperature = expected
+	b.actualTemperature = actual
+	b.refrigerator = refrigerator
+}
+
+// command handles a frame from the host, the lock is held.
+func (b *Board) command(function, frame byte, data []byte) {
+	switch function {
+	case ziman.FUNC_STATUS:
+		var refrigerator byte
+		if b.refrigerator {
+			refrigerator = 1
+		}
+		b.replyAfter(0, ziman.FrameBytes(function, frame, []byte{
+			byte(b.expectedTemperature), byte(b.actualTemperature), refrigerator,
+		}))
+	case ziman.FUNC_CHECK:
+		if len(data) < 2 {
+			return
+		}
+		b.replyAfter(0, ziman.FrameBytes(function, frame, data[:2]))
+	case ziman.FUNC_ROTATE, ziman.FUNC_UNLOCK:
+		if len(data) < 2 {
+			return
+		}
+		row, column := int(data[0]), int(data[1])
+		var success byte = 1
+		rows, columns := b.Rows, b.Columns
+		if rows == 0 {
+			rows = 6
+		}
+		if columns == 0 {
+			columns = 10
+		}
+		if row < 1 || row > rows || column < 1 || column > columns ||
+			b.failing[[2]int{row, column}] || b.random() < b.FailureRate {
+			success = 0
+		}
+		duration := b.MotorDuration
+		if duration == 0 {
+			duration = time.Second
+		}
+		reply := ziman.FrameBytes(function, frame, []byte{
+			data[0], data[1], byte(duration / (100 * time.Millisecond)), success,
+		})
+		b.history = append(b.history, reply)
+		if len(b.history) > 5 {
+			b.history = b.history[1:]
+		}
+		b.replyAfter(duration, reply)
+	case ziman.FUNC_LOOKUP:
+		// replies with the results of the last 5 rotations and unlocks, in
+		// the rotate format, written at once to keep the order
+		var replies []byte
+		for _, item := range b.history {
+			replies = append(replies, ziman.FrameBytes(ziman.FUNC_ROTATE, item[3], item[4:8])...)
+		}
+		if len(replies) > 0 {
+			b.replyAfter(0, replies)
+		}
+	}
+}
+
+func (b *Board) random() float64 {
+	if b.rand == nil {
+		b.rand = rand.New(rand.NewSource(b.Seed))
+	}
+	return b.rand.Float64()
+}
+
+func (b *Board) replyAfter(d time.Duration, data []byte) {
+	delay := b.Delay
+	if delay == 0 {
+		delay = 20 * time.Millisecond
+	}
+	time.AfterFunc(delay+d, func() {
+		b.reply(data)
+	})
+}
+
+// reply feeds data back to the host like a serial read loop would.
+func (b *Board) reply(data []byte) {
+	b.replyMu.Lock()
+	defer b.replyMu.Unlock()
+	if b.Faults == nil {
+		b.received = ziman.Process(append(b.received, data...), &b.channels)
+		return
+	}
+	b.Faults.Inbound(data, func(chunk []byte) {
+		b.received = ziman.Process(append(b.received, chunk...), &b.channels)
+	})
+}
+
+func valid(input []byte) bool {
+	if input[len(input)-1] != 0xfe {
+		return false
+	}
+	var sum byte
+	for i := 0; i < len(input)-2; i++ {
+		sum += input[i]
+	}
+	return sum == input[len(input)-2]
+}
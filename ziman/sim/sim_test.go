@@ -0,0 +1,112 @@
+package sim_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman/jsonrpc"
+	"github.com/caiguanhao/vending-processors/ziman/sim"
+)
+
+func newZiman() (*jsonrpc.Ziman, *sim.Board) {
+	board := sim.NewBoard()
+	board.MotorDuration = 100 * time.Millisecond
+	clients := &sync.Map{}
+	clients.Store("a", board)
+	return &jsonrpc.Ziman{Clients: clients}, board
+}
+
+func cell(row, column int) jsonrpc.BasicArgs {
+	return jsonrpc.BasicArgs{ClientID: "a", Row: row, Column: column}
+}
+
+func TestBoardStatus(t *testing.T) {
+	s, board := newZiman()
+	board.SetTemperature(4, 6, false)
+	var reply jsonrpc.StatusReply
+	if err := s.Status(&jsonrpc.StatusArgs{ClientID: "a"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	if reply.ExpectedTemperature != 4 || reply.ActualTemperature != 6 || reply.RefrigeratorOperating {
+		t.Errorf("status = %+v", reply)
+	}
+}
+
+func TestBoardRotate(t *testing.T) {
+	s, board := newZiman()
+	board.Fail(2, 2, true)
+	tests := []struct {
+		row, column int
+		success     bool
+	}{
+		{1, 1, true},
+		{2, 2, false}, // failing
+		{7, 1, false}, // out of range
+	}
+	for _, test := range tests {
+		var reply jsonrpc.RotateReply
+		if err := s.Rotate(&jsonrpc.RotateArgs{BasicArgs: cell(test.row, test.column)}, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if reply.Success != test.success || reply.Row != test.row || reply.Column != test.column || reply.Duration != 1 {
+			t.Errorf("%d-%d: reply = %+v", test.row, test.column, reply)
+		}
+	}
+	var unlock jsonrpc.UnlockReply
+	if err := s.Unlock(&jsonrpc.UnlockArgs{BasicArgs: cell(3, 4)}, &unlock); err != nil || !unlock.Success {
+		t.Errorf("unlock = %+v, err = %v", unlock, err)
+	}
+	var check jsonrpc.CheckReply
+	if err := s.Check(&jsonrpc.CheckArgs{BasicArgs: cell(3, 4)}, &check); err != nil || check.Row != 3 || check.Column != 4 {
+		t.Errorf("check = %+v, err = %v", check, err)
+	}
+}
+
+func TestBoardLookUp(t *testing.T) {
+	s, _ := newZiman()
+	for column := 1; column <= 6; column++ {
+		var reply jsonrpc.RotateReply
+		if err := s.Rotate(&jsonrpc.RotateArgs{BasicArgs: cell(1, column)}, &reply); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var reply jsonrpc.LookUpReply
+	if err := s.LookUp(&jsonrpc.LookUpArgs{ClientID: "a"}, &reply); err != nil {
+		t.Fatal(err)
+	}
+	// only the last 5 are kept
+	if len(reply.Replies) != 5 || reply.Replies[0].Column != 2 || reply.Replies[4].Column != 6 {
+		t.Errorf("replies = %+v", reply.Replies)
+	}
+}
+
+func TestBoardDefaults(t *testing.T) {
+	board := &sim.Board{MotorDuration: 10 * time.Millisecond}
+	clients := &sync.Map{}
+	clients.Store("a", board)
+	s := &jsonrpc.Ziman{Clients: clients}
+	tests := []struct {
+		row, column int
+		success     bool
+	}{
+		{6, 10, true},
+		{7, 1, false},
+		{1, 11, false},
+	}
+	for _, test := range tests {
+		var reply jsonrpc.RotateReply
+		if err := s.Rotate(&jsonrpc.RotateArgs{BasicArgs: cell(test.row, test.column)}, &reply); err != nil || reply.Success != test.success {
+			t.Errorf("%d-%d: reply = %+v, err = %v, want %v", test.row, test.column, reply, err, test.success)
+		}
+	}
+}
+
+func TestBoardFailureRate(t *testing.T) {
+	s, board := newZiman()
+	board.FailureRate = 1
+	var reply jsonrpc.RotateReply
+	if err := s.Rotate(&jsonrpc.RotateArgs{BasicArgs: cell(1, 1)}, &reply); err != nil || reply.Success {
+		t.Errorf("reply = %+v, err = %v", reply, err)
+	}
+}
@@ -0,0 +1,98 @@
+package ziman
+
+import (
+	"context"
+	"sort"
+
+	"github.com/caiguanhao/vending-processors/slots"
+)
+
+var (
+	ErrSlotDisabled   = slots.ErrDisabled
+	ErrSlotNotTracked = slots.ErrNotTracked
+)
+
+type (
+	// SlotHealth keeps the statistics of the cells of every client from the
+	// results of Rotate and Unlock, and disables a cell after too many
+	// failures in a row. Commands to a disabled cell fail with
+	// ErrSlotDisabled until Enable is called.
+	SlotHealth struct {
+		// consecutive failures before a cell is disabled, defaults to 3,
+		// negative means never
+		MaxFailures int
+		// optional, called when a cell is disabled
+		OnDisable func(clientId string, stats SlotStats)
+
+		health slots.Health
+	}
+
+	// SlotStats is the slots.Stats of a cell. AverageDuration is the time
+	// the motor ran as reported by the board, LastErrorCode is the reply of
+	// the board in hex.
+	SlotStats struct {
+		Row    int
+		Column int
+		slots.Stats
+	}
+)
+
+// Stats returns the statistics of the cells of the client, sorted by row and
+// column.
+func (h *SlotHealth) Stats(clientId string) []SlotStats {
+	list := []SlotStats{}
+	for cell, stats := range h.health.All(clientId) {
+		list = append(list, slotStats(cell.([2]int), stats))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Row != list[j].Row {
+			return list[i].Row < list[j].Row
+		}
+		return list[i].Column < list[j].Column
+	})
+	return list
+}
+
+func (h *SlotHealth) Get(clientId string, row, column int) (SlotStats, bool) {
+	cell := [2]int{row, column}
+	stats, ok := h.health.Get(clientId, cell)
+	return slotStats(cell, stats), ok
+}
+
+func (h *SlotHealth) Disabled(clientId string, row, column int) bool {
+	return h.health.Disabled(clientId, [2]int{row, column})
+}
+
+// Enable enables the cell and resets its consecutive failures, e.g. after
+// it has been fixed. It returns ErrSlotNotTracked if no command has been
+// recorded for the cell.
+func (h *SlotHealth) Enable(clientId string, row, column int) error {
+	return h.health.Enable(clientId, [2]int{row, column})
+}
+
+// reserve waits for other commands to the cell, see slots.Health.Reserve.
+func (h *SlotHealth) reserve(ctx context.Context, clientId string, row, column int) (func(), error) {
+	release, err := h.health.Reserve(ctx, clientId, [2]int{row, column})
+	if err != nil && err != ErrSlotDisabled {
+		err = contextError(ctx)
+	}
+	return release, err
+}
+
+// record adds the result of a command to the cell, duration is negative if
+// the command timed out and errorCode is ignored if ok is true.
+func (h *SlotHealth) record(clientId string, row, column, duration int, ok bool, errorCode string) {
+	cell := [2]int{row, column}
+	stats, disabled := h.health.Record(clientId, cell, h.MaxFailures, duration, ok, errorCode)
+	if disabled && h.OnDisable != nil {
+		h.OnDisable(clientId, slotStats(cell, stats))
+	}
+}
+
+func slotStats(cell [2]int, stats slots.Stats) SlotStats {
+	return SlotStats{
+		Row:    cell[0],
+		Column: cell[1],
+		Stats:  stats,
+	}
+}
@@ -0,0 +1,103 @@
+package ziman_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caiguanhao/vending-processors/ziman"
+)
+
+func TestSlotHealth(t *testing.T) {
+	m, board := newMachine()
+	board.MotorDuration = 300 * time.Millisecond
+	var disabled []ziman.SlotStats
+	m.SlotHealth = &ziman.SlotHealth{
+		MaxFailures: 2,
+		OnDisable: func(clientId string, stats ziman.SlotStats) {
+			disabled = append(disabled, stats)
+		},
+	}
+	ctx := context.Background()
+	if err := m.SlotHealth.Enable("a", 1, 4); err != ziman.ErrSlotNotTracked {
+		t.Errorf("enable untracked: err = %v, want ErrSlotNotTracked", err)
+	}
+	board.Fail(1, 4, true)
+	for i := 0; i < 2; i++ {
+		if reply, err := m.Rotate(ctx, 1, 4); err != nil || reply.Success {
+			t.Fatalf("reply = %+v, err = %v", reply, err)
+		}
+	}
+	if _, err := m.Rotate(ctx, 1, 4); err != ziman.ErrSlotDisabled {
+		t.Errorf("err = %v, want ErrSlotDisabled", err)
+	}
+	stats, _ := m.SlotHealth.Get("a", 1, 4)
+	if len(disabled) != 1 || !stats.Disabled || stats.Attempts != 2 || stats.ConsecutiveFailures != 2 {
+		t.Errorf("disabled = %+v, stats = %+v", disabled, stats)
+	}
+	// milliseconds the motor ran as reported by the board
+	if stats.AverageDuration != 300 {
+		t.Errorf("average duration = %v", stats.AverageDuration)
+	}
+	if err := m.SlotHealth.Enable("a", 1, 4); err != nil {
+		t.Fatal(err)
+	}
+	board.Fail(1, 4, false)
+	if reply, err := m.Rotate(ctx, 1, 4); err != nil || !reply.Success {
+		t.Errorf("after enable: reply = %+v, err = %v", reply, err)
+	}
+}
+
+func TestSlotHealthConcurrent(t *testing.T) {
+	m, board := newMachine()
+	m.Scheduler = &ziman.Scheduler{}
+	m.SlotHealth = &ziman.SlotHealth{MaxFailures: 1}
+	board.Fail(2, 5, true)
+	var wg sync.WaitGroup
+	errs := make(chan error, 3)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := m.Rotate(context.Background(), 2, 5)
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	sent := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			sent++
+		case ziman.ErrSlotDisabled:
+		default:
+			t.Errorf("err = %v", err)
+		}
+	}
+	// the others wait for the first one, which disables the cell
+	if stats, _ := m.SlotHealth.Get("a", 2, 5); sent != 1 || stats.Attempts != 1 {
+		t.Errorf("sent = %d, stats = %+v", sent, stats)
+	}
+}
+
+func TestSlotHealthWait(t *testing.T) {
+	m, _ := newMachine()
+	m.Scheduler = &ziman.Scheduler{}
+	m.SlotHealth = &ziman.SlotHealth{}
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if reply, err := m.Rotate(context.Background(), 2, 5); err != nil || !reply.Success {
+				t.Errorf("reply = %+v, err = %v", reply, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if stats, _ := m.SlotHealth.Get("a", 2, 5); stats.Attempts != 3 {
+		t.Errorf("stats = %+v", stats)
+	}
+}
@@ -1,10 +1,10 @@
 package ziman
 
 import (
-	"bytes"
 	"fmt"
 	"log"
 	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -21,69 +21,123 @@ const (
 	KEY_UNLOCK = "unlock"
 )
 
-func Process(_data []byte, multiChannels ...*sync.Map) []byte {
-	// ziman replies
-	for i := 0; i < len(_data)-1; i++ {
-		if _data[i] != 0xa8 {
-			continue
+// Process dispatches the frames in data with a Decoder and returns the
+// bytes of the unfinished frame, which are never more than
+// DEFAULT_MAX_BUFFER_SIZE, so that garbage on the line is dropped instead of
+// kept until the next read.
+func Process(data []byte, multiChannels ...*sync.Map) []byte {
+	d := NewDecoder(nil)
+	d.Write(data)
+	for _, frame := range d.frames {
+		log.Println("received", frame.Bytes)
+		frame.Dispatch(multiChannels...)
+	}
+	return d.buf
+}
+
+func dispatch(data []byte, multiChannels ...*sync.Map) {
+	for _, channels := range multiChannels {
+		if eventType := deliver(channels, data); eventType != "" {
+			publish(channels, eventType, data)
 		}
-		size := int(_data[i+1])
-		if i+size > len(_data) {
-			continue
+	}
+}
+
+// deliver sends data to the command waiting for it, if there is no such
+// command it returns the type of the event to publish, which is empty if
+// data is a lookup result.
+func deliver(channels *sync.Map, data []byte) (eventType string) {
+	var key string
+	if data[2] == FUNC_STATUS && len(data) == 9 {
+		if channel, ok := channels.Load(KEY_STATUS); ok {
+			send(channels, channel.(chan []byte), data)
+			return
 		}
-		data := _data[i : i+size]
-		if !validData(data) {
-			continue
+		if deliverRaw(channels, data) {
+			return
 		}
-		index := bytes.IndexByte(_data[i+size:], 0xa8)
-		if index == -1 {
-			_data = []byte{}
-		} else {
-			_data = _data[i+size+index:]
-			i = -1 // i will be reset to 0 after this loop
+		return EVENT_UNMATCHED
+	} else if data[2] == FUNC_CHECK && len(data) == 8 {
+		key = KEY_CHECK
+	} else if data[2] == FUNC_ROTATE && len(data) == 10 {
+		key = KEY_ROTATE
+	} else if data[2] == FUNC_UNLOCK && len(data) == 10 {
+		key = KEY_UNLOCK
+	} else {
+		if deliverRaw(channels, data) {
+			return
 		}
-		log.Println("received", data)
-		if data[2] == FUNC_STATUS && len(data) == 9 {
-			for _, channels := range multiChannels {
-				if channel, ok := channels.Load(KEY_STATUS); ok {
-					channel.(chan []byte) <- data
-				}
-			}
-		} else if data[2] == FUNC_CHECK && len(data) == 8 {
-			frame, row, column := int(data[3]), int(data[4]), int(data[5])
-			key := fmt.Sprintf("%s-%d-%d-%d", KEY_CHECK, frame, row, column)
-			for _, channels := range multiChannels {
-				if channel, ok := channels.LoadAndDelete(key); ok {
-					channel.(chan []byte) <- data
-				}
-			}
-		} else if data[2] == FUNC_ROTATE && len(data) == 10 {
-			frame, row, column := int(data[3]), int(data[4]), int(data[5])
-			key := fmt.Sprintf("%s-%d-%d-%d", KEY_ROTATE, frame, row, column)
-			for _, channels := range multiChannels {
-				if channel, ok := channels.LoadAndDelete(key); ok {
-					channel.(chan []byte) <- data
-				} else {
-					if channel, ok := channels.Load(KEY_LOOKUP); ok {
-						channel.(chan []byte) <- data
-					}
-				}
-			}
-		} else if data[2] == FUNC_UNLOCK && len(data) == 10 {
-			frame, row, column := int(data[3]), int(data[4]), int(data[5])
-			key := fmt.Sprintf("%s-%d-%d-%d", KEY_UNLOCK, frame, row, column)
-			for _, channels := range multiChannels {
-				if channel, ok := channels.LoadAndDelete(key); ok {
-					channel.(chan []byte) <- data
-				} else {
-					if channel, ok := channels.Load(KEY_LOOKUP); ok {
-						channel.(chan []byte) <- data
-					}
-				}
+		return EVENT_UNKNOWN
+	}
+	frame, row, column := int(data[3]), int(data[4]), int(data[5])
+	key = fmt.Sprintf("%s-%d-%d-%d", key, frame, row, column)
+	if channel, ok := channels.LoadAndDelete(key); ok {
+		send(channels, channel.(chan []byte), data)
+		return
+	}
+	if deliverRaw(channels, data) {
+		return
+	}
+	late := false
+	if sequence, ok := channels.Load(sequenceKey{}); ok {
+		late = sequence.(*Sequence).Expired(data[3])
+	}
+	if data[2] != FUNC_CHECK {
+		if channel, ok := channels.Load(KEY_LOOKUP); ok {
+			send(channels, channel.(chan []byte), data)
+			if late {
+				// it is a lookup result too, but still published as the
+				// evidence of a command that has timed out
+				return EVENT_LATE_REPLY
 			}
+			return
 		}
 	}
-	return _data
+	if late {
+		return EVENT_LATE_REPLY
+	}
+	return EVENT_UNMATCHED
+}
+
+// deliverRaw sends data to the SendRaw command waiting for its function.
+func deliverRaw(channels *sync.Map, data []byte) bool {
+	if channel, ok := channels.LoadAndDelete(FunctionKey(data[2])); ok {
+		send(channels, channel.(chan []byte), data)
+		return true
+	}
+	return false
+}
+
+// FunctionKey returns the channel key of SendRaw commands waiting for a reply
+// with the function code.
+func FunctionKey(function byte) string {
+	return fmt.Sprintf("function-%02x", function)
+}
+
+// droppedKey is where the channels of a client keep the number of its
+// dropped replies, it is not a string so it never clashes with a reply key.
+type droppedKey struct{}
+
+// Dropped returns the number of replies Process has dropped for the client
+// with the channels.
+func Dropped(channels *sync.Map) uint64 {
+	if counter, ok := channels.Load(droppedKey{}); ok {
+		return atomic.LoadUint64(counter.(*uint64))
+	}
+	return 0
+}
+
+// send drops data if the reply of the command has already been received,
+// e.g. a duplicated reply, so that the read loop is never blocked. Every drop
+// is logged and counted in Dropped.
+func send(channels *sync.Map, channel chan []byte, data []byte) {
+	select {
+	case channel <- data:
+	default:
+		counter, _ := channels.LoadOrStore(droppedKey{}, new(uint64))
+		n := atomic.AddUint64(counter.(*uint64), 1)
+		log.Printf("dropped reply #%d: % X", n, data)
+	}
 }
 
 func validData(input []byte) bool {